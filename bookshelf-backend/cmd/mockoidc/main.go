@@ -0,0 +1,194 @@
+// cmd/mockoidc/main.go
+
+// mockoidc is a throwaway OpenID Connect issuer for trying the bookshelf
+// OIDC login locally. Every authorization request is approved immediately
+// for the identity configured through the environment:
+//
+//	MOCK_OIDC_ADDR   listen address            (default :9000)
+//	MOCK_OIDC_ISSUER issuer URL                (default http://localhost:9000)
+//	MOCK_EMAIL       email claim               (default staff@mail.com)
+//	MOCK_NAME        name claim                (default Staff Member)
+//	MOCK_GROUPS      comma separated groups    (default bookshelf-editors)
+//
+// Point the server at it with OIDC_ISSUER=http://localhost:9000 and
+// OIDC_CLIENT_ID=bookshelf. Keys and codes live in memory only.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func getenv(k, d string) string {
+	if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+		return v
+	}
+	return d
+}
+
+type pendingCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+type issuer struct {
+	url  string
+	key  *rsa.PrivateKey
+	kid  string
+	mu   sync.Mutex
+	code map[string]pendingCode
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *issuer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.url,
+		"authorization_endpoint":                s.url + "/authorize",
+		"token_endpoint":                        s.url + "/token",
+		"jwks_uri":                              s.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *issuer) jwks(w http.ResponseWriter, _ *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "kid": s.kid, "use": "sig", "alg": "RS256",
+		"n": b64(s.key.N.Bytes()), "e": b64(big.NewInt(int64(s.key.E)).Bytes()),
+	}}})
+}
+
+func (s *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	s.mu.Lock()
+	s.code[code] = pendingCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	s.mu.Lock()
+	p, ok := s.code[r.PostForm.Get("code")]
+	delete(s.code, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(p.expires):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or expired code"})
+		return
+	case p.redirectURI != r.PostForm.Get("redirect_uri") || p.clientID != r.PostForm.Get("client_id"):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "client or redirect mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	email := getenv("MOCK_EMAIL", "staff@mail.com")
+	var groups []string
+	for _, g := range strings.Split(getenv("MOCK_GROUPS", "bookshelf-editors"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.url,
+		"sub":            "mock|" + email,
+		"aud":            p.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          p.nonce,
+		"email":          email,
+		"email_verified": true,
+		"name":           getenv("MOCK_NAME", "Staff Member"),
+		"groups":         groups,
+	})
+	t.Header["kid"] = s.kid
+	idToken, err := t.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func main() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &issuer{
+		url:  strings.TrimRight(getenv("MOCK_OIDC_ISSUER", "http://localhost:9000"), "/"),
+		key:  key,
+		kid:  "mock-" + time.Now().Format("20060102150405"),
+		code: map[string]pendingCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+
+	addr := getenv("MOCK_OIDC_ADDR", ":9000")
+	log.Printf("🧪 Mock OIDC issuer %s listening on %s\n", s.url, addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
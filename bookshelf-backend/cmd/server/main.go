@@ -9,30 +9,36 @@
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
+// @description "Bearer <access token>" or "ApiKey <personal api key>"
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-
 	// swagger docs (generated by swag init)
 	_ "github.com/giovannyptr/bookshelf/docs"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
 	// internal
+	"github.com/giovannyptr/bookshelf/internal/api"
 	"github.com/giovannyptr/bookshelf/internal/auth"
 	"github.com/giovannyptr/bookshelf/internal/books"
+	"github.com/giovannyptr/bookshelf/internal/catalog"
+	"github.com/giovannyptr/bookshelf/internal/cursor"
+	"github.com/giovannyptr/bookshelf/internal/mail"
+	"github.com/giovannyptr/bookshelf/internal/metadata"
 	"github.com/giovannyptr/bookshelf/internal/users"
 	"github.com/giovannyptr/bookshelf/models"
 
@@ -47,43 +53,48 @@ func getenv(k, d string) string {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	// ---- env ----
 	port := getenv("PORT", "8080")
-	dsn := getenv("DATABASE_URL", "")
-	if dsn == "" {
-		host := getenv("DB_HOST", "127.0.0.1")
-		dbPort := getenv("DB_PORT", "5433")
-		user := getenv("DB_USER", "bookshelf")
-		pass := getenv("DB_PASSWORD", "bookshelf")
-		name := getenv("DB_NAME", "bookshelf")
-		ssl := getenv("DB_SSLMODE", "disable")
-		dsn = "postgres://" + user + ":" + pass + "@" + host + ":" + dbPort + "/" + name + "?sslmode=" + ssl
-	}
-	log.Printf("DEBUG CWD=%s", must(os.Getwd()))
-	log.Printf("DEBUG DATABASE_URL=%q\n", dsn)
-
 	adminEmail := strings.ToLower(getenv("ADMIN_EMAIL", "admin@mail.com"))
 	adminPassword := getenv("ADMIN_PASSWORD", "adminbookshelf")
 	allowedOrigins := getenv("ALLOWED_ORIGINS", "*")
+	appURL := getenv("APP_BASE_URL", "http://localhost:5173")
+	publicURL := getenv("PUBLIC_BASE_URL", "")
 
-	// ---- db ----
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// ---- jwt keys ----
+	keys, err := auth.LoadKeyManager()
 	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
+		log.Fatalf("jwt keys: %v", err)
 	}
-	log.Println("✅ Connected to PostgreSQL successfully")
+	log.Printf("🔑 Signing tokens with key %q\n", keys.ActiveKID())
+
+	// ---- mail ----
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatalf("mail: %v", err)
+	}
+
+	// ---- db ----
+	db := openDB()
 
 	// ---- migrations ----
-	if err := db.AutoMigrate(&models.User{}, &models.Book{}); err != nil {
-		log.Fatalf("auto-migrate error: %v", err)
+	if getenv("MIGRATE_ON_START", "true") == "true" {
+		if _, err := migrateUp(db); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		log.Println("📘 Migrations up to date")
 	}
-	log.Println("📘 Auto-migration completed")
 
 	// ---- seed admin ----
 	ur := users.NewRepository(db)
 	if _, err := ur.ByEmail(adminEmail); err != nil {
 		hash, _ := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
-		u := models.User{Email: adminEmail, Password: string(hash), Name: "Admin", Role: "admin"}
+		now := time.Now()
+		u := models.User{Email: adminEmail, Password: string(hash), Name: "Admin", Role: models.RoleAdmin, EmailVerifiedAt: &now}
 		if err := ur.Create(&u); err == nil {
 			log.Printf("✅ Admin user created: %s\n", adminEmail)
 		} else {
@@ -94,13 +105,21 @@ func main() {
 	// ---- gin ----
 	gin.SetMode(gin.DebugMode)
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	r.Use(api.RequestID(), gin.LoggerWithFormatter(api.LogFormatter), gin.CustomRecovery(api.Recovered))
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(api.NoRoute)
+	r.NoMethod(api.NoMethod)
+	// only these proxies may name the client in X-Forwarded-For, which the
+	// login throttle keys on
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
 
 	// ---- CORS ----
 	cfg := cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match", "If-None-Match", api.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", "ETag", api.RequestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -142,19 +161,71 @@ func main() {
 	})
 
 	// ---- auth ----
-	ah := auth.NewHandler(ur)
+	tr := auth.NewTokenRepository(db)
+	var attempts auth.AttemptStore
+	switch store := getenv("LOGIN_THROTTLE_STORE", "postgres"); store {
+	case "postgres":
+		attempts = auth.NewDBAttemptStore(db)
+	case "memory":
+		attempts = auth.NewMemoryAttemptStore()
+	default:
+		log.Fatalf("unknown LOGIN_THROTTLE_STORE %q (want postgres or memory)", store)
+	}
+	ah := auth.NewHandler(ur, tr, keys, mailer, auth.NewThrottler(attempts), auth.Config{
+		AppURL:          appURL,
+		RequireAdmin2FA: getenv("REQUIRE_ADMIN_2FA", "false") == "true",
+		OIDC:            oidcFromEnv(),
+	})
 	ah.RegisterRoutes(r)
 
 	// ---- books ----
 	br := books.NewRepository(db)
-	bh := books.NewHandler(br, uploadDir)
+	bh := books.NewHandler(br, books.Terms{
+		Authors:    catalog.NewRepository(db, catalog.Authors),
+		Categories: catalog.NewRepository(db, catalog.Categories),
+		Publishers: catalog.NewRepository(db, catalog.Publishers),
+	}, metadataFromEnv(), cursorSigner(), uploadDir, publicURL)
 
 	api := r.Group("/")
 	api.GET("/books", bh.List)
+	api.GET("/books/isbn/:isbn", bh.ByISBN)
+	api.GET("/books/export", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksRead), bh.Export)
+	api.GET("/books/lookup/:isbn", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.LookupISBN)
+	api.GET("/books/trash", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksDelete), bh.Trash)
 	api.GET("/books/:id", bh.Detail)
-	api.POST("/books", auth.AuthRequired(), bh.Create)
-	api.PUT("/books/:id", auth.AuthRequired(), bh.Update)
-	api.DELETE("/books/:id", auth.AuthRequired(), bh.Delete)
+	api.POST("/books/isbn/:isbn", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.CreateFromISBN)
+	api.POST("/books/import", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.Import)
+	api.GET("/books/import/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.ImportReport)
+	api.GET("/books/import/:id/errors.csv", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.ImportErrors)
+	api.POST("/books", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.Create)
+	api.PUT("/books/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.Update)
+	api.PATCH("/books/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.Patch)
+	api.DELETE("/books/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksDelete), bh.Delete)
+	api.POST("/books/:id/restore", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksDelete), bh.Restore)
+	api.GET("/books/:id/history", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.History)
+	api.POST("/books/:id/revert/:revision", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), bh.Revert)
+
+	// deleted books are purged once they have been in the trash for
+	// TRASH_RETENTION_DAYS; 0 keeps them until restored
+	if days := trashRetentionDays(); days > 0 {
+		log.Printf("🗑️ Purging books deleted more than %d days ago\n", days)
+		go bh.PurgeTrash(context.Background(), time.Duration(days)*24*time.Hour, time.Hour)
+	}
+
+	// ---- authors, categories, publishers ----
+	for _, kind := range []catalog.Kind{catalog.Authors, catalog.Categories, catalog.Publishers} {
+		ch := catalog.NewHandler(catalog.NewRepository(db, kind), br)
+		path := "/" + kind.Table
+		api.GET(path, ch.List)
+		api.GET(path+"/:id", ch.Detail)
+		api.POST(path, ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), ch.Create)
+		api.PUT(path+"/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksWrite), ch.Update)
+		api.DELETE(path+"/:id", ah.AuthRequired(), auth.RequirePermission(auth.PermBooksDelete), ch.Delete)
+	}
+
+	// ---- users (admin) ----
+	uh := users.NewHandler(ur, ah, auth.GetUserID)
+	uh.RegisterRoutes(r, ah.AuthRequired(), auth.RequirePermission(auth.PermUsersManage))
 
 	// ---- swagger ui ----
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -165,4 +236,89 @@ func main() {
 	}
 }
 
-func must[T any](v T, _ error) T { return v }
+func trashRetentionDays() int {
+	s := getenv("TRASH_RETENTION_DAYS", "30")
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		log.Fatalf("TRASH_RETENTION_DAYS %q is not a number of days", s)
+	}
+	return days
+}
+
+// trustedProxies lists the TRUSTED_PROXIES addresses or CIDRs, comma
+// separated. Without any, X-Forwarded-For is ignored.
+func trustedProxies() []string {
+	var out []string
+	for _, p := range strings.Split(getenv("TRUSTED_PROXIES", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// cursorSigner signs pagination cursors with CURSOR_SECRET. Without it a
+// random key is used, so cursors break on restart and between replicas.
+func cursorSigner() *cursor.Signer {
+	if secret := getenv("CURSOR_SECRET", ""); secret != "" {
+		return cursor.NewSigner([]byte(secret))
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("failed to generate cursor key: %v", err)
+	}
+	log.Printf("⚠️  CURSOR_SECRET is not set, pagination cursors won't survive a restart")
+	return cursor.NewSigner(key)
+}
+
+// metadataFromEnv picks the ISBN metadata source, or returns nil when
+// METADATA_PROVIDER=none.
+func metadataFromEnv() metadata.Provider {
+	switch p := getenv("METADATA_PROVIDER", "openlibrary"); p {
+	case "openlibrary":
+		return metadata.NewOpenLibrary(getenv("METADATA_BASE_URL", "https://openlibrary.org"))
+	case "none":
+		return nil
+	default:
+		log.Fatalf("unknown METADATA_PROVIDER %q (want openlibrary or none)", p)
+		return nil
+	}
+}
+
+// oidcFromEnv configures OpenID Connect login, or returns nil when
+// OIDC_ISSUER is unset.
+func oidcFromEnv() *auth.OIDCProvider {
+	issuer := getenv("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil
+	}
+	cfg := auth.OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     getenv("OIDC_CLIENT_ID", ""),
+		ClientSecret: getenv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getenv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+		Scopes:       strings.Fields(getenv("OIDC_SCOPES", "openid email profile")),
+		GroupsClaim:  getenv("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMap:      map[string]string{},
+		DefaultRole:  getenv("OIDC_DEFAULT_ROLE", models.RoleViewer),
+	}
+	if cfg.ClientID == "" {
+		log.Fatal("OIDC_ISSUER is set but OIDC_CLIENT_ID is not")
+	}
+	if !auth.IsValidRole(cfg.DefaultRole) {
+		log.Fatalf("OIDC_DEFAULT_ROLE %q is not a role", cfg.DefaultRole)
+	}
+	// OIDC_ROLE_MAP="bookshelf-admins=admin,librarians=editor"
+	for _, pair := range strings.Split(getenv("OIDC_ROLE_MAP", ""), ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if !auth.IsValidRole(strings.TrimSpace(role)) {
+			log.Fatalf("OIDC_ROLE_MAP: %q is not a role", role)
+		}
+		cfg.RoleMap[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+	log.Printf("🔐 OIDC login enabled with issuer %s\n", issuer)
+	return auth.NewOIDCProvider(cfg)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/giovannyptr/bookshelf/internal/migrate"
+	"github.com/giovannyptr/bookshelf/migrations"
+)
+
+// openDB connects using DATABASE_URL or the DB_* variables.
+func openDB() *gorm.DB {
+	dsn := getenv("DATABASE_URL", "")
+	if dsn == "" {
+		host := getenv("DB_HOST", "127.0.0.1")
+		dbPort := getenv("DB_PORT", "5433")
+		user := getenv("DB_USER", "bookshelf")
+		pass := getenv("DB_PASSWORD", "bookshelf")
+		name := getenv("DB_NAME", "bookshelf")
+		ssl := getenv("DB_SSLMODE", "disable")
+		dsn = "postgres://" + user + ":" + pass + "@" + host + ":" + dbPort + "/" + name + "?sslmode=" + ssl
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		log.Fatalf("failed to connect database: %v", err)
+	}
+	log.Println("✅ Connected to PostgreSQL successfully")
+	return db
+}
+
+func newMigrator(db *gorm.DB) (*migrate.Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(sqlDB, migrations.FS)
+}
+
+func migrateUp(db *gorm.DB) (int, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return 0, err
+	}
+	return m.Up(context.Background(), logMigration)
+}
+
+func logMigration(format string, args ...any) { log.Printf("📘 "+format, args...) }
+
+const migrateUsage = `usage: server migrate <command>
+
+  up         apply all pending migrations
+  down [n]   roll back the last n migrations (default 1)
+  status     list migrations and whether they are applied`
+
+// runMigrate implements "server migrate ..." and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	m, err := newMigrator(openDB())
+	if err != nil {
+		log.Printf("migrate: %v", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx, logMigration)
+		if err != nil {
+			log.Printf("migrate up: %v", err)
+			return 1
+		}
+		log.Printf("✅ %d migration(s) applied", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		n, err := m.Down(ctx, steps, logMigration)
+		if err != nil {
+			log.Printf("migrate down: %v", err)
+			return 1
+		}
+		log.Printf("✅ %d migration(s) rolled back", n)
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			log.Printf("migrate status: %v", err)
+			return 1
+		}
+		for _, s := range st {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			switch {
+			case s.Missing:
+				state += " (file missing)"
+			case s.Modified:
+				state += " (MODIFIED since applied)"
+			}
+			fmt.Printf("%04d  %-24s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
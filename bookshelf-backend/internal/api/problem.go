@@ -0,0 +1,183 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ---------- errors (RFC 7807 problem details) ----------
+
+// Every error is answered as application/problem+json: the RFC 7807
+// members plus a stable code to branch on, the request id to quote in
+// bug reports and, for invalid input, the message of each bad field.
+
+// Error codes. Most follow from the status; a few name a condition
+// clients handle specially.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeValidation           = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnsupportedMedia     = "unsupported_media_type"
+	CodePreconditionRequired = "precondition_required"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeInternal             = "internal"
+	CodeBadGateway           = "bad_gateway"
+	CodeUnavailable          = "unavailable"
+
+	CodeAccountDisabled = "account_disabled"
+	CodeMFARequired     = "mfa_required"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           CodeBadRequest,
+	http.StatusUnauthorized:         CodeUnauthorized,
+	http.StatusForbidden:            CodeForbidden,
+	http.StatusNotFound:             CodeNotFound,
+	http.StatusMethodNotAllowed:     CodeMethodNotAllowed,
+	http.StatusConflict:             CodeConflict,
+	http.StatusPreconditionFailed:   CodePreconditionFailed,
+	http.StatusUnsupportedMediaType: CodeUnsupportedMedia,
+	http.StatusPreconditionRequired: CodePreconditionRequired,
+	http.StatusTooManyRequests:      CodeTooManyRequests,
+	http.StatusInternalServerError:  CodeInternal,
+	http.StatusBadGateway:           CodeBadGateway,
+	http.StatusServiceUnavailable:   CodeUnavailable,
+}
+
+// Error is an error with the problem it is answered with. Err, the cause,
+// is logged but never sent.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	Fields map[string]string
+	Err    error
+}
+
+// NewError builds an Error with the code that goes with status.
+func NewError(status int, detail string) *Error {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Detail, e.Err)
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// WithCode returns e with a more specific code.
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// Internal is a 500 answered with detail; err is only logged.
+func Internal(err error, detail string) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: detail, Err: err}
+}
+
+// NotFound is a 404 for err if it is gorm.ErrRecordNotFound; other errors
+// are returned as they are.
+func NotFound(err error, detail string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Detail: detail, Err: err}
+	}
+	return err
+}
+
+// Problem is the application/problem+json body.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	RequestID string            `json:"requestId,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// Abort answers err as a problem and stops the handler chain. An *Error
+// is sent as it is; a missing record is a 404 and a unique or foreign key
+// violation a 409. Anything else is a 500 whose cause is only logged,
+// under the request id the client gets.
+func Abort(c *gin.Context, err error) {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		e = &Error{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "not found", Err: err}
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		e = &Error{Status: http.StatusConflict, Code: CodeConflict, Detail: "already exists", Err: err}
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		e = &Error{Status: http.StatusConflict, Code: CodeConflict, Detail: "still referenced elsewhere", Err: err}
+	default:
+		e = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "internal server error", Err: err}
+	}
+	rid := RequestIDOf(c)
+	if e.Status >= 500 {
+		log.Printf("⚠️  %s %s failed [%s]: %v", c.Request.Method, c.Request.URL.Path, rid, e)
+	}
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(e.Status, Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  c.Request.URL.Path,
+		Code:      e.Code,
+		RequestID: rid,
+		Fields:    e.Fields,
+	})
+}
+
+// Fail answers status with msg as the detail.
+func Fail(c *gin.Context, status int, msg string) {
+	Abort(c, NewError(status, msg))
+}
+
+// FailFields answers with msg and what is wrong with each request field.
+func FailFields(c *gin.Context, status int, msg string, fields map[string]string) {
+	e := NewError(status, msg)
+	if status == http.StatusBadRequest {
+		e.Code = CodeValidation
+	}
+	e.Fields = fields
+	Abort(c, e)
+}
+
+// NoRoute answers requests no route matches.
+func NoRoute(c *gin.Context) {
+	Fail(c, http.StatusNotFound, fmt.Sprintf("no route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// NoMethod answers requests to a path that has routes, but not for the
+// method.
+func NoMethod(c *gin.Context) {
+	Fail(c, http.StatusMethodNotAllowed, c.Request.Method+" is not allowed here")
+}
+
+// Recovered answers a panic; gin.CustomRecovery has already logged it.
+func Recovered(c *gin.Context, recovered any) {
+	Abort(c, fmt.Errorf("panic: %v", recovered))
+}
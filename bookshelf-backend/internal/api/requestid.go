@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the id of a request, both ways.
+const RequestIDHeader = "X-Request-ID"
+
+const ctxRequestID = "requestID"
+
+// RequestID tags every request with an id: the client's X-Request-ID if
+// it sent a sane one, so a proxy's id carries through, or a new UUID.
+// The id is echoed in the response header, in every problem and in the
+// access log.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !saneRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(ctxRequestID, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// saneRequestID accepts up to 128 printable ASCII characters, so ids can
+// be logged as they are.
+func saneRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDOf returns the id RequestID gave c, or "".
+func RequestIDOf(c *gin.Context) string {
+	return c.GetString(ctxRequestID)
+}
+
+// LogFormatter is gin's default access log line with the request id.
+func LogFormatter(p gin.LogFormatterParams) string {
+	if p.Latency > time.Minute {
+		p.Latency = p.Latency.Truncate(time.Second)
+	}
+	rid, _ := p.Keys[ctxRequestID].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v [%s]\n%s",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		p.StatusCode,
+		p.Latency,
+		p.ClientIP,
+		p.Method,
+		p.Path,
+		rid,
+		p.ErrorMessage,
+	)
+}
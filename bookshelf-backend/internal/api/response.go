@@ -9,9 +9,9 @@ import (
 
 // ---------- tiny response helpers ----------
 
+// Envelope wraps successful responses; errors are problems, see Abort.
 type Envelope struct {
-	Data  any    `json:"data,omitempty"`
-	Error string `json:"error,omitempty"`
+	Data any `json:"data,omitempty"`
 }
 
 func OK(c *gin.Context, data any) {
@@ -22,21 +22,119 @@ func Created(c *gin.Context, data any) {
 	c.JSON(http.StatusCreated, Envelope{Data: data})
 }
 
-func Fail(c *gin.Context, status int, msg string) {
-	c.JSON(status, Envelope{Error: msg})
-}
-
 // ---------- Swagger DTOs (for nicer docs) ----------
 
-// ErrorResponse is used in Swagger examples.
+// ErrorResponse is a Problem as used in Swagger examples.
 type ErrorResponse struct {
-	Error string `json:"error" example:"something went wrong"`
+	Type      string `json:"type"      example:"about:blank"`
+	Title     string `json:"title"     example:"Not Found"`
+	Status    int    `json:"status"    example:"404"`
+	Detail    string `json:"detail"    example:"book not found"`
+	Instance  string `json:"instance"  example:"/books/42"`
+	Code      string `json:"code"      example:"not_found"`
+	RequestID string `json:"requestId" example:"0b5a3c1e-8f2d-4d47-9a51-3f0e6c2b7d90"`
+}
+
+// FieldErrorResponse is an ErrorResponse naming the invalid fields.
+type FieldErrorResponse struct {
+	Type      string            `json:"type"      example:"about:blank"`
+	Title     string            `json:"title"     example:"Bad Request"`
+	Status    int               `json:"status"    example:"400"`
+	Detail    string            `json:"detail"    example:"invalid book"`
+	Instance  string            `json:"instance"  example:"/books"`
+	Code      string            `json:"code"      example:"validation_failed"`
+	RequestID string            `json:"requestId" example:"0b5a3c1e-8f2d-4d47-9a51-3f0e6c2b7d90"`
+	Fields    map[string]string `json:"fields"    example:"price:must be >= 0"`
 }
 
 // PagedBooks is the paginated payload for GET /books (used in Swagger).
+// Total is left out with count=false and page when paging by cursor.
 type PagedBooks struct {
+	Items  []models.Book `json:"items"`
+	Total  int64         `json:"total" example:"42"`
+	Page   int           `json:"page"  example:"1"`
+	Limit  int           `json:"limit" example:"10"`
+	Next   *string       `json:"next"  example:"eyJzIjoiY3JlYXRlZF9hdCIsIm8iOiJERVNDIiwidiI6IjIwMjUtMDEtMDJUMDM6MDQ6MDVaIiwiaSI6NDJ9.sig"`
+	Prev   *string       `json:"prev"`
+	Facets BookFacets    `json:"facets"`
+}
+
+// PagedTrash is the payload for GET /books/trash (used in Swagger).
+type PagedTrash struct {
 	Items []models.Book `json:"items"`
-	Total int64         `json:"total" example:"42"`
+	Total int64         `json:"total" example:"3"`
 	Page  int           `json:"page"  example:"1"`
 	Limit int           `json:"limit" example:"10"`
 }
+
+// PagedHistory is the payload for GET /books/{id}/history.
+type PagedHistory struct {
+	Items []BookHistoryEntry `json:"items"`
+	Total int64              `json:"total" example:"5"`
+	Page  int                `json:"page"  example:"1"`
+	Limit int                `json:"limit" example:"10"`
+}
+
+// BookHistoryEntry is a revision of a book with the fields it changed.
+type BookHistoryEntry struct {
+	models.BookRevision
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is one field a revision changed. Authors, categories and
+// publishers are lists of names.
+type FieldChange struct {
+	Field string `json:"field" example:"price"`
+	From  any    `json:"from"  swaggertype:"string" example:"60000"`
+	To    any    `json:"to"    swaggertype:"string" example:"65000"`
+}
+
+// BookFacets counts the books matching a GET /books query by category,
+// author, publisher and price. Each dimension ignores its own filter, so
+// picking one category still shows how many books the others have.
+type BookFacets struct {
+	Categories []FacetCount `json:"categories"`
+	Authors    []FacetCount `json:"authors"`
+	Publishers []FacetCount `json:"publishers"`
+	Price      PriceFacet   `json:"price"`
+}
+
+// FacetCount is one value of a facet; the most frequent values come first.
+type FacetCount struct {
+	ID    uint   `json:"id"    example:"3"`
+	Name  string `json:"name"  example:"Fiction"`
+	Count int64  `json:"count" example:"12"`
+}
+
+// PriceFacet gives the price range of the matching books, for a slider,
+// and their count per price bucket.
+type PriceFacet struct {
+	Min     *float64      `json:"min" example:"25000"`
+	Max     *float64      `json:"max" example:"450000"`
+	Buckets []PriceBucket `json:"buckets"`
+}
+
+// PriceBucket counts books with From <= price < To; To is null for the
+// last bucket.
+type PriceBucket struct {
+	From  float64  `json:"from"  example:"50000"`
+	To    *float64 `json:"to"    example:"100000"`
+	Count int64    `json:"count" example:"7"`
+}
+
+// PagedUsers is the paginated payload for GET /users (used in Swagger).
+type PagedUsers struct {
+	Items []models.User `json:"items"`
+	Total int64         `json:"total" example:"3"`
+	Page  int           `json:"page"  example:"1"`
+	Limit int           `json:"limit" example:"20"`
+}
+
+// PagedTerms is the paginated payload for GET /authors, /categories and
+// /publishers (used in Swagger).
+type PagedTerms struct {
+	Items []models.Term `json:"items"`
+	Total int64         `json:"total" example:"12"`
+	Page  int           `json:"page"  example:"1"`
+	Limit int           `json:"limit" example:"50"`
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ---------- request validation ----------
+
+// Request DTOs declare their rules in `binding` struct tags, checked by
+// the validator gin already runs on bind. On top of the built-in rules
+// (required, min, max, gte, email, oneof, ...) these are registered:
+//
+//	notblank  a string with more than whitespace
+//	password  8 to 72 bytes, mixing letters with digits or symbols
+//
+// Failures are reported per field, by JSON name.
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(jsonName)
+	_ = v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	_ = v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return passwordProblem(fl.Field().String()) == ""
+	})
+}
+
+// jsonName names fields in errors as they are sent; "-" skips a field.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// passwordProblem says what is wrong with a new password, or "".
+func passwordProblem(s string) string {
+	switch {
+	case utf8.RuneCountInString(s) < 8:
+		return "must be at least 8 characters"
+	case len(s) > 72:
+		// bcrypt ignores everything past 72 bytes
+		return "must be at most 72 bytes"
+	case !strings.ContainsFunc(s, unicode.IsLetter) || !strings.ContainsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) }):
+		return "must mix letters with digits or symbols"
+	}
+	return ""
+}
+
+// BindJSON decodes the JSON body into obj and checks its binding tags. It
+// answers 400, with the invalid fields if that is what failed, and
+// reports whether the request may go on.
+func BindJSON(c *gin.Context, obj any) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	if fields := FieldErrors(err); fields != nil {
+		FailFields(c, http.StatusBadRequest, "invalid request", fields)
+	} else if errors.Is(err, io.EOF) {
+		Fail(c, http.StatusBadRequest, "body must be a JSON object")
+	} else if errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, new(*json.SyntaxError)) {
+		Fail(c, http.StatusBadRequest, "body is not valid JSON")
+	} else {
+		// decoder errors name Go types, so they are not passed on
+		Abort(c, &Error{Status: http.StatusBadRequest, Code: CodeBadRequest, Detail: "body does not match the expected JSON", Err: err})
+	}
+	return false
+}
+
+// Validate checks the binding tags of a struct that was not bound from
+// the request, such as a model the request was applied to. It returns
+// the invalid fields, or nil.
+func Validate(obj any) map[string]string {
+	return FieldErrors(binding.Validator.ValidateStruct(obj))
+}
+
+// FieldErrors maps a validation or JSON type error to a message per field.
+// It returns nil for other errors.
+func FieldErrors(err error) map[string]string {
+	var ve validator.ValidationErrors
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &ve):
+		fields := make(map[string]string, len(ve))
+		for _, fe := range ve {
+			// the namespace starts with the struct's own name, if it has one
+			path := fe.Namespace()
+			if _, rest, ok := strings.Cut(path, "."); ok {
+				path = rest
+			}
+			if _, ok := fields[path]; !ok {
+				fields[path] = fieldMessage(fe)
+			}
+		}
+		return fields
+	case errors.As(err, &te) && te.Field != "":
+		return map[string]string{te.Field: "must be " + typeName(te.Type)}
+	}
+	return nil
+}
+
+// fieldMessage words a failed rule.
+func fieldMessage(fe validator.FieldError) string {
+	p := fe.Param()
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "email":
+		return "must be a valid email address"
+	case "password":
+		s, _ := fe.Value().(string)
+		return passwordProblem(s)
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(p, " ", ", ")
+	case "min", "max", "len":
+		bound := map[string]string{"min": "at least", "max": "at most", "len": "exactly"}[fe.Tag()]
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be %s %s characters", bound, p)
+		case reflect.Slice, reflect.Map, reflect.Array:
+			if fe.Tag() == "min" && p == "1" {
+				return "must not be empty"
+			}
+			return fmt.Sprintf("must have %s %s items", bound, p)
+		}
+		return fmt.Sprintf("must be %s %s", bound, p)
+	case "gte":
+		return "must be >= " + p
+	case "gt":
+		return "must be > " + p
+	case "lte":
+		return "must be <= " + p
+	case "lt":
+		return "must be < " + p
+	}
+	return "is invalid"
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,15 @@
+package auth
+
+import "github.com/giovannyptr/bookshelf/models"
+
+// EndSessions revokes every refresh token family and API key of uid.
+// Access tokens already issued die with their family.
+func (h *Handler) EndSessions(uid uint) error {
+	if err := h.tokens.RevokeAllForUser(uid); err != nil {
+		return err
+	}
+	return h.tokens.RevokeAPIKeysForUser(uid)
+}
+
+// SendPasswordReset mails u a reset link, as /auth/forgot-password does.
+func (h *Handler) SendPasswordReset(u *models.User) error { return h.sendPasswordReset(u) }
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+var ErrAPIKeyInvalid = errors.New("invalid api key")
+
+const apiKeyTag = "bks"
+
+// lastUsedGranularity limits last_used_at writes to one per key per minute.
+const lastUsedGranularity = time.Minute
+
+// newAPIKey returns the full key and its prefix, e.g.
+// "bks_k3md9qxa_<43 chars>" and "bks_k3md9qxa".
+func newAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 5)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyTag + "_" + strings.ToLower(b32.EncodeToString(id))
+	return prefix + "_" + secret, prefix, nil
+}
+
+func splitScopes(s string) []string { return strings.Fields(s) }
+
+// ---------- storage ----------
+
+func (r *TokenRepository) CreateAPIKey(k *models.APIKey) error { return r.db.Create(k).Error }
+
+func (r *TokenRepository) ListAPIKeys(uid uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("user_id = ?", uid).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes key id if it belongs to uid and reports whether it did.
+func (r *TokenRepository) RevokeAPIKey(uid, id uint) (bool, error) {
+	res := r.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, uid).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+// RevokeAPIKeysForUser revokes every key of uid.
+func (r *TokenRepository) RevokeAPIKeysForUser(uid uint) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", uid).
+		Update("revoked_at", time.Now()).Error
+}
+
+// AuthenticateAPIKey resolves a raw key to its record, rejecting unknown,
+// revoked and expired keys, and stamps last_used_at.
+func (r *TokenRepository) AuthenticateAPIKey(raw string) (*models.APIKey, error) {
+	i := strings.LastIndexByte(raw, '_')
+	if i <= 0 || !strings.HasPrefix(raw, apiKeyTag+"_") {
+		return nil, ErrAPIKeyInvalid
+	}
+	var k models.APIKey
+	if err := r.db.Where("prefix = ?", raw[:i]).First(&k).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashToken(raw))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if k.RevokedAt != nil || (k.ExpiresAt != nil && now.After(*k.ExpiresAt)) {
+		return nil, ErrAPIKeyInvalid
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > lastUsedGranularity {
+		r.db.Model(&k).UpdateColumn("last_used_at", now)
+	}
+	return &k, nil
+}
+
+// ---------- handlers ----------
+
+type createAPIKeyDTO struct {
+	Name          string   `json:"name"          binding:"required,notblank,max=100" example:"nightly import"`
+	Scopes        []string `json:"scopes"        binding:"required,min=1" example:"books:read,books:write"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"gte=0" example:"90"`
+}
+
+type apiKeyResponse struct {
+	models.APIKey
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key,omitempty"`
+}
+
+func toAPIKeyResponse(k models.APIKey) apiKeyResponse {
+	return apiKeyResponse{APIKey: k, Scopes: splitScopes(k.Scopes)}
+}
+
+// createAPIKey godoc
+// @Summary Create a personal API key
+// @Description Scopes must be permissions of the caller's role. The key is only returned in this response. API keys cannot be used to manage API keys.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body createAPIKeyDTO true "Key name, scopes and optional lifetime"
+// @Success 201 {object} api.Envelope
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Router  /auth/api-keys [post]
+func (h *Handler) createAPIKey(c *gin.Context) {
+	var in createAPIKeyDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	if mfaRequired(c) {
+		return
+	}
+	uid, _ := GetUserID(c)
+	role, _ := GetUserRole(c)
+	for _, s := range in.Scopes {
+		if !HasPermission(role, Permission(s)) {
+			api.Fail(c, http.StatusBadRequest, "scope "+s+" is not granted to your role")
+			return
+		}
+	}
+	raw, prefix, err := newAPIKey()
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to generate key"))
+		return
+	}
+	k := models.APIKey{
+		UserID:  uid,
+		Name:    strings.TrimSpace(in.Name),
+		Prefix:  prefix,
+		KeyHash: hashToken(raw),
+		Scopes:  strings.Join(in.Scopes, " "),
+	}
+	if in.ExpiresInDays > 0 {
+		exp := time.Now().AddDate(0, 0, in.ExpiresInDays)
+		k.ExpiresAt = &exp
+	}
+	if err := h.tokens.CreateAPIKey(&k); err != nil {
+		api.Abort(c, api.Internal(err, "failed to store key"))
+		return
+	}
+	out := toAPIKeyResponse(k)
+	out.Key = raw
+	api.Created(c, out)
+}
+
+// listAPIKeys godoc
+// @Summary List your API keys
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} api.Envelope
+// @Failure 401 {object} api.ErrorResponse
+// @Router  /auth/api-keys [get]
+func (h *Handler) listAPIKeys(c *gin.Context) {
+	uid, _ := GetUserID(c)
+	keys, err := h.tokens.ListAPIKeys(uid)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to list keys"))
+		return
+	}
+	out := make([]apiKeyResponse, len(keys))
+	for i, k := range keys {
+		out[i] = toAPIKeyResponse(k)
+	}
+	api.OK(c, out)
+}
+
+// revokeAPIKey godoc
+// @Summary Revoke one of your API keys
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "Key ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /auth/api-keys/{id} [delete]
+func (h *Handler) revokeAPIKey(c *gin.Context) {
+	uid, _ := GetUserID(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "api key not found")
+		return
+	}
+	ok, err := h.tokens.RevokeAPIKey(uid, uint(id))
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to revoke key"))
+		return
+	}
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "api key not found")
+		return
+	}
+	api.OK(c, gin.H{"message": "api key revoked"})
+}
+
+// sessionOnly rejects requests authenticated with an API key, so a leaked
+// key can't be used to mint more keys.
+func sessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(ctxScopes); ok {
+			api.Fail(c, http.StatusForbidden, "not allowed with an api key")
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,33 +1,88 @@
 package auth
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/mail"
 	"github.com/giovannyptr/bookshelf/internal/users"
 	"github.com/giovannyptr/bookshelf/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Config holds the auth settings that come from the environment.
+type Config struct {
+	// AppURL is the frontend base URL used in links sent by email.
+	AppURL string
+	// RequireAdmin2FA denies admin permissions to sessions that did not
+	// pass a TOTP check.
+	RequireAdmin2FA bool
+	// OIDC enables /auth/oidc/* when set.
+	OIDC *OIDCProvider
+}
+
 type Handler struct {
-	users *users.Repository
+	users    *users.Repository
+	tokens   *TokenRepository
+	keys     *KeyManager
+	mailer   mail.Mailer
+	throttle *Throttler
+	cfg      Config
 }
 
-func NewHandler(ur *users.Repository) *Handler { return &Handler{users: ur} }
+func NewHandler(ur *users.Repository, tr *TokenRepository, km *KeyManager, m mail.Mailer, th *Throttler, cfg Config) *Handler {
+	return &Handler{users: ur, tokens: tr, keys: km, mailer: m, throttle: th, cfg: cfg}
+}
 
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	g := r.Group("/auth")
 	g.POST("/register", h.register)
 	g.POST("/login", h.login)
-	g.GET("/me", AuthRequired(), h.me)
+	g.POST("/login/2fa", h.loginTOTP)
+	g.POST("/refresh", h.refresh)
+	g.POST("/logout", h.AuthRequired(), sessionOnly(), h.logout)
+	g.POST("/forgot-password", h.forgotPassword)
+	g.POST("/reset-password", h.resetPassword)
+	g.POST("/verify-email", h.verifyEmail)
+	g.POST("/verify-email/resend", h.AuthRequired(), sessionOnly(), h.resendVerification)
+	g.POST("/confirm-email", h.confirmEmail)
+
+	me := g.Group("/me", h.AuthRequired())
+	me.GET("", h.me)
+	me.PATCH("", sessionOnly(), h.updateMe)
+	me.POST("/password", sessionOnly(), h.changePassword)
+	me.DELETE("", sessionOnly(), h.deleteMe)
+
+	tf := g.Group("/2fa", h.AuthRequired(), sessionOnly())
+	tf.POST("/setup", h.setupTOTP)
+	tf.POST("/verify", h.verifyTOTP)
+	tf.POST("/disable", h.disableTOTP)
+
+	if h.cfg.OIDC != nil {
+		g.GET("/oidc/login", h.oidcLogin)
+		g.GET("/oidc/callback", h.oidcCallback)
+	}
+
+	ak := g.Group("/api-keys", h.AuthRequired(), sessionOnly())
+	ak.POST("", h.createAPIKey)
+	ak.GET("", h.listAPIKeys)
+	ak.DELETE("/:id", h.revokeAPIKey)
+
+	r.GET("/.well-known/jwks.json", h.jwks)
 }
 
 type registerDTO struct {
-	Email    string `json:"email" example:"user@mail.com"`
-	Password string `json:"password" example:"12345678"`
-	Name     string `json:"name" example:"User Name"`
+	Email    string `json:"email"    binding:"required,email,max=254" example:"user@mail.com"`
+	Password string `json:"password" binding:"required,password" example:"secret-pass-1"`
+	Name     string `json:"name"     binding:"required,notblank,max=100" example:"User Name"`
 }
 
 // register godoc
@@ -37,13 +92,12 @@ type registerDTO struct {
 // @Produce json
 // @Param   payload body registerDTO true "Register payload"
 // @Success 201 {object} map[string]any
-// @Failure 400 {object} api.ErrorResponse
+// @Failure 400 {object} api.FieldErrorResponse
 // @Failure 409 {object} api.ErrorResponse
 // @Router  /auth/register [post]
 func (h *Handler) register(c *gin.Context) {
 	var in registerDTO
-	if err := c.ShouldBindJSON(&in); err != nil {
-		api.Fail(c, http.StatusBadRequest, err.Error())
+	if !api.BindJSON(c, &in) {
 		return
 	}
 	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
@@ -52,22 +106,25 @@ func (h *Handler) register(c *gin.Context) {
 		return
 	}
 	hash, _ := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
-	u := models.User{Email: in.Email, Password: string(hash), Name: in.Name, Role: "user"}
+	u := models.User{Email: in.Email, Password: string(hash), Name: strings.TrimSpace(in.Name), Role: models.RoleViewer}
 	if err := h.users.Create(&u); err != nil {
-		api.Fail(c, http.StatusInternalServerError, err.Error())
+		api.Abort(c, err)
 		return
 	}
-	token, _ := GenerateToken(u.ID, u.Email, u.Role)
-	c.JSON(http.StatusCreated, gin.H{"token": token, "user": gin.H{"id": u.ID, "email": u.Email, "name": u.Name, "role": u.Role}})
+	if err := h.sendVerification(&u); err != nil {
+		log.Printf("⚠️  failed to issue verification token for user %d: %v", u.ID, err)
+	}
+	h.startSession(c, http.StatusCreated, &u, "pwd")
 }
 
 type loginDTO struct {
-	Email    string `json:"email" example:"admin@mail.com"`
-	Password string `json:"password" example:"adminbookshelf"`
+	Email    string `json:"email"    binding:"required" example:"admin@mail.com"`
+	Password string `json:"password" binding:"required" example:"adminbookshelf"`
 }
 
 // login godoc
 // @Summary Login
+// @Description Repeated failures per account and per client IP are slowed down exponentially and eventually locked out; the wait is returned in Retry-After. When the account has 2FA enabled the response is {"twoFactorRequired": true, "challengeToken": ...} and the login is finished at /auth/login/2fa.
 // @Tags    auth
 // @Accept  json
 // @Produce json
@@ -75,32 +132,89 @@ type loginDTO struct {
 // @Success 200 {object} map[string]any
 // @Failure 400 {object} api.ErrorResponse
 // @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
 // @Router  /auth/login [post]
 func (h *Handler) login(c *gin.Context) {
 	var in loginDTO
-	if err := c.ShouldBindJSON(&in); err != nil {
-		api.Fail(c, http.StatusBadRequest, err.Error())
+	if !api.BindJSON(c, &in) {
 		return
 	}
 	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	ip := c.ClientIP()
+
+	wait, err := h.throttle.Reserve(in.Email, ip)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check login attempts"))
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return
+	}
+
 	u, err := h.users.ByEmail(in.Email)
-	if err != nil || bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(in.Password)) != nil {
+	known := err == nil && u.Password != ""
+	hash := dummyHash()
+	if known {
+		hash = []byte(u.Password)
+	}
+	// unknown emails and accounts without a password cost a compare too,
+	// so the response time doesn't tell them apart
+	match := bcrypt.CompareHashAndPassword(hash, []byte(in.Password)) == nil
+	if !known || !match {
+		if err := h.throttle.Failure(in.Email, ip); err != nil {
+			log.Printf("⚠️  failed to record login failure: %v", err)
+		}
+		if u != nil {
+			_ = h.users.RecordLoginFailure(u.ID)
+		}
 		api.Fail(c, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
-	token, _ := GenerateToken(u.ID, u.Email, u.Role)
-	c.JSON(http.StatusOK, gin.H{"token": token, "user": gin.H{"id": u.ID, "email": u.Email, "name": u.Name, "role": u.Role}})
+	if u.DisabledAt != nil {
+		accountDisabled(c, http.StatusForbidden)
+		return
+	}
+	if u.TOTPEnabledAt != nil {
+		// second step happens at /auth/login/2fa; the counters are only
+		// reset once that succeeds
+		challenge, err := h.keys.GenerateChallenge(u.ID, u.Email, []string{"pwd"})
+		if err != nil {
+			api.Abort(c, api.Internal(err, "failed to sign challenge"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "challengeToken": challenge})
+		return
+	}
+	if err := h.throttle.Success(in.Email, ip); err != nil {
+		log.Printf("⚠️  failed to reset login attempts: %v", err)
+	}
+	_ = h.users.RecordLogin(u.ID)
+	h.startSession(c, http.StatusOK, u, "pwd")
+}
+
+// dummyHash is compared against when the email is unknown. It uses the
+// cost real passwords are hashed with, so both take as long.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("bookshelf-no-such-user"), bcrypt.DefaultCost)
+	return hash
+})
+
+// tooManyAttempts answers 429 with Retry-After rounded up to whole seconds.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(secs))
+	api.Fail(c, http.StatusTooManyRequests, fmt.Sprintf("too many failed login attempts, retry in %ds", secs))
 }
 
-// me godoc
-// @Summary Current user
+// jwks godoc
+// @Summary Public keys for verifying bookshelf tokens
 // @Tags    auth
 // @Produce json
-// @Security BearerAuth
-// @Success 200 {object} map[string]any
-// @Router  /auth/me [get]
-func (h *Handler) me(c *gin.Context) {
-	uid, _ := GetUserID(c)
-	role, _ := GetUserRole(c)
-	c.JSON(http.StatusOK, gin.H{"id": uid, "role": role})
+// @Success 200 {object} JWKSet
+// @Router  /.well-known/jwks.json [get]
+func (h *Handler) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
 }
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no JWT signing key configured: set JWT_KEYS_DIR or JWT_SECRET")
+
+// signingKey is one entry of the key ring. HMAC keys have a nil public key
+// and are never published in the JWKS.
+type signingKey struct {
+	kid      string
+	method   jwt.SigningMethod
+	private  any
+	public   crypto.PublicKey
+	retireAt time.Time // zero while the key is active or without a deadline
+}
+
+// KeyManager holds every key tokens may be signed or verified with. Exactly
+// one key is active and used for signing; the others stay valid for
+// verification until their retirement time so that tokens issued just before
+// a rotation keep working.
+type KeyManager struct {
+	mu      sync.RWMutex
+	keys    map[string]*signingKey
+	active  string
+	overlap time.Duration
+	issuer  string
+}
+
+// NewKeyManager returns an empty key ring. overlap is how long a key keeps
+// verifying tokens after it stops being the active one.
+func NewKeyManager(issuer string, overlap time.Duration) *KeyManager {
+	return &KeyManager{keys: map[string]*signingKey{}, overlap: overlap, issuer: issuer}
+}
+
+func newSigningKey(kid string, priv any) (*signingKey, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least 2048 bits", kid)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case []byte:
+		if len(k) == 0 {
+			return nil, fmt.Errorf("key %s: empty HMAC secret", kid)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodHS256, private: k}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", kid, priv)
+	}
+}
+
+// AddKey adds a verification-capable key without changing the active one.
+func (m *KeyManager) AddKey(kid string, priv any) error {
+	k, err := newSigningKey(kid, priv)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, dup := m.keys[kid]; dup {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+	m.keys[kid] = k
+	return nil
+}
+
+// SetActive makes kid the signing key. The previously active key retires
+// after the overlap window.
+func (m *KeyManager) SetActive(kid string) error {
+	return m.activate(kid, time.Now())
+}
+
+func (m *KeyManager) activate(kid string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown key id %q", kid)
+	}
+	if prev, ok := m.keys[m.active]; ok && m.active != kid {
+		prev.retireAt = at.Add(m.overlap)
+	}
+	k.retireAt = time.Time{}
+	m.active = kid
+	return nil
+}
+
+// Rotate adds a new key and immediately starts signing with it.
+func (m *KeyManager) Rotate(kid string, priv any) error {
+	if err := m.AddKey(kid, priv); err != nil {
+		return err
+	}
+	return m.SetActive(kid)
+}
+
+// ActiveKID returns the id of the key new tokens are signed with.
+func (m *KeyManager) ActiveKID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active
+}
+
+func (m *KeyManager) usable(k *signingKey, now time.Time) bool {
+	return k.retireAt.IsZero() || now.Before(k.retireAt)
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	k, ok := m.keys[m.active]
+	m.mu.RUnlock()
+	if !ok {
+		return "", ErrNoSigningKey
+	}
+	t := jwt.NewWithClaims(k.method, claims)
+	t.Header["kid"] = k.kid
+	return t.SignedString(k.private)
+}
+
+// Parse verifies tokenStr against the key named by its kid header and
+// decodes it into claims.
+func (m *KeyManager) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "EdDSA", "HS256"}),
+	}
+	if m.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(m.issuer))
+	}
+	return jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		m.mu.RLock()
+		k, ok := m.keys[kid]
+		m.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if !m.usable(k, time.Now()) {
+			return nil, fmt.Errorf("key %q has been retired", kid)
+		}
+		// never let the token pick a different algorithm than the key's
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", t.Method.Alg(), kid)
+		}
+		if k.public != nil {
+			return k.public, nil
+		}
+		return k.private, nil
+	}, opts...)
+}
+
+// JWK is a single public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the body of /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of all asymmetric keys that can still
+// verify tokens, active key first.
+func (m *KeyManager) JWKS() JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	kids := make([]string, 0, len(m.keys))
+	for kid := range m.keys {
+		kids = append(kids, kid)
+	}
+	sort.Slice(kids, func(i, j int) bool {
+		if kids[i] == m.active || kids[j] == m.active {
+			return kids[i] == m.active
+		}
+		return kids[i] < kids[j]
+	})
+	set := JWKSet{Keys: []JWK{}}
+	for _, kid := range kids {
+		k := m.keys[kid]
+		if !m.usable(k, now) {
+			continue
+		}
+		b64 := base64.RawURLEncoding.EncodeToString
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA", Kid: kid, Use: "sig", Alg: k.method.Alg(),
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP", Kid: kid, Use: "sig", Alg: k.method.Alg(),
+				Crv: "Ed25519", X: b64(pub),
+			})
+		}
+	}
+	return set
+}
+
+// parsePrivateKeyPEM accepts PKCS#8 (RSA or Ed25519) and PKCS#1 RSA keys.
+func parsePrivateKeyPEM(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// LoadKeyManager builds the key ring from the environment:
+//
+//   - JWT_KEYS_DIR: directory of PEM private keys; each file's base name
+//     (without .pem) is its kid.
+//   - JWT_ACTIVE_KID: key to sign with; defaults to the greatest kid, so
+//     date-named files rotate by simply adding a newer one.
+//   - JWT_KEY_OVERLAP: how long inactive keys keep verifying, counted from
+//     when the active key file was written (default 24h).
+//   - JWT_SECRET: legacy HS256 secret, used only when JWT_KEYS_DIR is unset.
+//   - JWT_ISSUER: "iss" claim set and required on tokens (default bookshelf).
+//
+// It returns ErrNoSigningKey when neither source yields a key.
+func LoadKeyManager() (*KeyManager, error) {
+	m := NewKeyManager(getenv("JWT_ISSUER", "bookshelf"), durationEnv("JWT_KEY_OVERLAP", 24*time.Hour))
+
+	dir := strings.TrimSpace(os.Getenv("JWT_KEYS_DIR"))
+	if dir == "" {
+		if s := os.Getenv("JWT_SECRET"); s != "" {
+			if err := m.Rotate("hs256", []byte(s)); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+		return nil, ErrNoSigningKey
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no *.pem files in %s", ErrNoSigningKey, dir)
+	}
+	written := map[string]time.Time{}
+	var kids []string
+	for _, f := range files {
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		priv, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", f, err)
+		}
+		if err := m.AddKey(kid, priv); err != nil {
+			return nil, err
+		}
+		if st, err := os.Stat(f); err == nil {
+			written[kid] = st.ModTime()
+		}
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	active := getenv("JWT_ACTIVE_KID", kids[len(kids)-1])
+	if _, ok := m.keys[active]; !ok {
+		return nil, fmt.Errorf("JWT_ACTIVE_KID %q not found in %s", active, dir)
+	}
+	m.active = active
+	for _, kid := range kids {
+		if kid != active {
+			m.keys[kid].retireAt = written[active].Add(m.overlap)
+		}
+	}
+	return m, nil
+}
+
+func getenv(k, d string) string {
+	if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+		return v
+	}
+	return d
+}
@@ -1,34 +1,112 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
 )
 
 const ctxUserID = "userID"
 const ctxUserRole = "userRole"
+const ctxClaims = "claims"
+const ctxMFAPending = "mfaPending"
+const ctxScopes = "apiKeyScopes" // only set for API key requests
 
-func AuthRequired() gin.HandlerFunc {
+// mfaPending reports whether a token for role lacks the second factor the
+// configuration demands for that role.
+func (h *Handler) mfaPending(role string, claims *Claims) bool {
+	return h.cfg.RequireAdmin2FA && role == models.RoleAdmin && !claims.hasAMR("otp")
+}
+
+// AuthRequired accepts either "Bearer <access token>" or "ApiKey <key>".
+// Tokens are rejected if revoked, keys if revoked or expired, and both if
+// their user no longer exists or is disabled. The role put on the context
+// is the one currently stored for the user, not the one baked into the
+// credential.
+func (h *Handler) AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		h := c.GetHeader("Authorization")
-		if h == "" || !strings.HasPrefix(strings.ToLower(h), "bearer ") {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ok": false, "error": "missing bearer token"})
-			return
-		}
-		tokenStr := strings.TrimSpace(h[len("Bearer "):])
-		claims, err := ParseToken(tokenStr)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ok": false, "error": "invalid token"})
-			return
+		scheme, cred, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		cred = strings.TrimSpace(cred)
+		switch {
+		case cred == "":
+			api.Fail(c, http.StatusUnauthorized, "missing bearer token or api key")
+		case strings.EqualFold(scheme, "bearer"):
+			h.authBearer(c, cred)
+		case strings.EqualFold(scheme, "apikey"):
+			h.authAPIKey(c, cred)
+		default:
+			api.Fail(c, http.StatusUnauthorized, "unsupported authorization scheme")
 		}
-		c.Set(ctxUserID, claims.UserID)
-		c.Set(ctxUserRole, claims.Role)
-		c.Next()
 	}
 }
 
+func (h *Handler) authBearer(c *gin.Context, tokenStr string) {
+	claims, err := h.keys.ParseToken(tokenStr)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	revoked, err := h.tokens.IsRevoked(claims.ID, claims.SessionID)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check token"))
+		return
+	}
+	if revoked {
+		api.Fail(c, http.StatusUnauthorized, "token revoked")
+		return
+	}
+	u, err := h.users.ByID(claims.UserID)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.DisabledAt != nil {
+		accountDisabled(c, http.StatusUnauthorized)
+		return
+	}
+	c.Set(ctxUserID, u.ID)
+	c.Set(ctxUserRole, u.Role)
+	c.Set(ctxClaims, claims)
+	// Such a session can still reach /auth/* (e.g. to enroll in 2FA)
+	// but RequireRole/RequirePermission turn it away.
+	c.Set(ctxMFAPending, h.mfaPending(u.Role, claims))
+	c.Next()
+}
+
+func (h *Handler) authAPIKey(c *gin.Context, raw string) {
+	k, err := h.tokens.AuthenticateAPIKey(raw)
+	if errors.Is(err, ErrAPIKeyInvalid) {
+		api.Fail(c, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check api key"))
+		return
+	}
+	u, err := h.users.ByID(k.UserID)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.DisabledAt != nil {
+		accountDisabled(c, http.StatusUnauthorized)
+		return
+	}
+	c.Set(ctxUserID, u.ID)
+	c.Set(ctxUserRole, u.Role)
+	c.Set(ctxScopes, splitScopes(k.Scopes))
+	c.Next()
+}
+
+// accountDisabled answers a request made for a disabled account.
+func accountDisabled(c *gin.Context, status int) {
+	api.Abort(c, api.NewError(status, "account disabled").WithCode(api.CodeAccountDisabled))
+}
+
 // helpers if needed by handlers
 func GetUserID(c *gin.Context) (uint, bool) {
 	v, ok := c.Get(ctxUserID)
@@ -46,3 +124,11 @@ func GetUserRole(c *gin.Context) (string, bool) {
 	role, _ := v.(string)
 	return role, true
 }
+func getClaims(c *gin.Context) (*Claims, bool) {
+	v, ok := c.Get(ctxClaims)
+	if !ok {
+		return nil, false
+	}
+	cl, ok := v.(*Claims)
+	return cl, ok
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a local OpenID provider serving discovery, a JWKS with
+// one RSA key and a token endpoint handing out idToken.
+type mockIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	kid     string
+	idToken string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, kid: "k1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.URL,
+			AuthorizationEndpoint: m.URL + "/authorize",
+			TokenEndpoint:         m.URL + "/token",
+			JWKSURI:               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []rawJWK{{
+			Kty: "RSA", Kid: m.kid, Use: "sig",
+			N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good-code" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// claims returns valid ID token claims for client "bookshelf" and nonce
+// "n0nce", for tests to change.
+func (m *mockIssuer) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            "bookshelf",
+		"sub":            "user-1",
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "n0nce",
+		"email":          " Staff@Mail.com",
+		"email_verified": true,
+		"name":           "Staff Member",
+		"groups":         []string{"librarians", "staff"},
+		"amr":            []string{"pwd", "otp"},
+	}
+}
+
+// sign signs claims with key under the kid header, if any.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (m *mockIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{Issuer: m.URL, ClientID: "bookshelf", RedirectURL: "http://app.test/auth/oidc/callback"})
+}
+
+func TestOIDCVerify(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	id, err := p.Verify(ctx, sign(t, m.key, m.kid, m.claims()), "n0nce")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if id.Subject != "user-1" || id.Email != "staff@mail.com" || !id.EmailVerified || id.Name != "Staff Member" ||
+		!slices.Equal(id.Groups, []string{"librarians", "staff"}) || !slices.Equal(id.AMR, []string{"pwd", "otp"}) {
+		t.Errorf("identity = %+v", id)
+	}
+
+	for name, claims := range map[string]func(jwt.MapClaims){
+		"without email_verified": func(c jwt.MapClaims) { delete(c, "email_verified") },
+		"email_verified false":   func(c jwt.MapClaims) { c["email_verified"] = false },
+	} {
+		c := m.claims()
+		claims(c)
+		id, err := p.Verify(ctx, sign(t, m.key, m.kid, c), "n0nce")
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", name, err)
+		}
+		if id.EmailVerified {
+			t.Errorf("%s: email counted as verified", name)
+		}
+	}
+}
+
+func TestOIDCVerifyRejects(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := m.claims()
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"bad signature", sign(t, other, m.kid, m.claims()), "n0nce"},
+		{"unknown kid", sign(t, m.key, "k2", m.claims()), "n0nce"},
+		{"other issuer", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.test" })), "n0nce"},
+		{"other audience", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { c["aud"] = "another-app" })), "n0nce"},
+		{"expired", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() })), "n0nce"},
+		{"no expiry", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { delete(c, "exp") })), "n0nce"},
+		{"nonce mismatch", sign(t, m.key, m.kid, m.claims()), "other-nonce"},
+		{"no nonce", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { delete(c, "nonce") })), ""},
+		{"no subject", sign(t, m.key, m.kid, with(func(c jwt.MapClaims) { delete(c, "sub") })), "n0nce"},
+		{"not a jwt", "not.a.jwt", "n0nce"},
+	}
+	// an HMAC token keyed with the public modulus must not pass as RS256
+	hs, err := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims()).SignedString(m.key.N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests = append(tests, struct{ name, token, nonce string }{"HS256", hs, "n0nce"})
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, m.claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests = append(tests, struct{ name, token, nonce string }{"alg none", none, "n0nce"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, err := p.Verify(ctx, tt.token, tt.nonce); err == nil {
+				t.Errorf("Verify accepted the token: %+v", id)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	p := NewOIDCProvider(OIDCConfig{Issuer: m.URL + "/", ClientID: "bookshelf"})
+	if _, err := p.Verify(context.Background(), sign(t, m.key, m.kid, m.claims()), "n0nce"); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("Verify with a mismatched issuer: err = %v", err)
+	}
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	m := newMockIssuer(t)
+	m.idToken = sign(t, m.key, m.kid, m.claims())
+	p := m.provider()
+	ctx := context.Background()
+
+	u, err := p.AuthCodeURL(ctx, "st4te", "n0nce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, m.URL+"/authorize?") || !strings.Contains(u, "code_challenge_method=S256") ||
+		!strings.Contains(u, "nonce=n0nce") || !strings.Contains(u, "state=st4te") {
+		t.Errorf("AuthCodeURL = %s", u)
+	}
+	if _, err := p.Exchange(ctx, "bad-code", "verifier"); err == nil {
+		t.Error("Exchange accepted a bad code")
+	}
+	raw, err := p.Exchange(ctx, "good-code", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := p.Verify(ctx, raw, "n0nce"); err != nil || id.Subject != "user-1" {
+		t.Errorf("Verify of the exchanged token = (%+v, %v)", id, err)
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcCookie   = "bookshelf_oidc"
+	oidcStateTTL = 10 * time.Minute
+	useOIDCState = "oidc_state"
+)
+
+// oidcStateClaims travel in a signed, HttpOnly cookie between /oidc/login
+// and /oidc/callback, so no server-side state is needed.
+type oidcStateClaims struct {
+	Use      string `json:"use"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"cv"`
+	jwt.RegisteredClaims
+}
+
+// oidcLogin godoc
+// @Summary Start login with the company identity provider
+// @Description Redirects to the provider (authorization code flow with PKCE). The provider sends the browser back to /auth/oidc/callback.
+// @Tags    auth
+// @Success 302
+// @Failure 502 {object} api.ErrorResponse
+// @Router  /auth/oidc/login [get]
+func (h *Handler) oidcLogin(c *gin.Context) {
+	var st oidcStateClaims
+	var err error
+	for _, f := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		if *f, err = randomToken(32); err != nil {
+			api.Abort(c, api.Internal(err, "failed to start login"))
+			return
+		}
+	}
+	st.Use = useOIDCState
+	st.ExpiresAt = jwt.NewNumericDate(time.Now().Add(oidcStateTTL))
+	st.Issuer = h.keys.issuer
+
+	target, err := h.cfg.OIDC.AuthCodeURL(c.Request.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Printf("⚠️  oidc: %v", err)
+		api.Fail(c, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+	cookie, err := h.keys.Sign(st)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to start login"))
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, cookie, int(oidcStateTTL.Seconds()), "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, target)
+}
+
+// oidcCallback godoc
+// @Summary Finish login with the company identity provider
+// @Description Validates state and nonce, verifies the ID token, links or provisions the user by email and maps provider groups to a role. Redirects to APP_BASE_URL/login/oidc with the token pair in the URL fragment. When the account has 2FA enabled and the provider did not check a second factor (amr mfa, otp or hwk), the fragment holds twoFactorRequired=true and a challengeToken for /auth/login/2fa instead.
+// @Tags    auth
+// @Param   code  query string true "Authorization code"
+// @Param   state query string true "State from /auth/oidc/login"
+// @Success 302
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 502 {object} api.ErrorResponse
+// @Router  /auth/oidc/callback [get]
+func (h *Handler) oidcCallback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		api.Fail(c, http.StatusUnauthorized, "identity provider returned "+e)
+		return
+	}
+	raw, err := c.Cookie(oidcCookie)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, "login session missing or expired, start again")
+		return
+	}
+	c.SetCookie(oidcCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	var st oidcStateClaims
+	if _, err := h.keys.Parse(raw, &st); err != nil || st.Use != useOIDCState {
+		api.Fail(c, http.StatusBadRequest, "login session missing or expired, start again")
+		return
+	}
+	if st.State == "" || c.Query("state") != st.State {
+		api.Fail(c, http.StatusBadRequest, "state mismatch")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		api.Fail(c, http.StatusBadRequest, "missing code")
+		return
+	}
+
+	ctx := c.Request.Context()
+	idToken, err := h.cfg.OIDC.Exchange(ctx, code, st.Verifier)
+	if err != nil {
+		log.Printf("⚠️  oidc: %v", err)
+		api.Fail(c, http.StatusBadGateway, "code exchange with identity provider failed")
+		return
+	}
+	id, err := h.cfg.OIDC.Verify(ctx, idToken, st.Nonce)
+	if err != nil {
+		log.Printf("⚠️  oidc: %v", err)
+		api.Fail(c, http.StatusUnauthorized, "invalid id token")
+		return
+	}
+
+	u, err := h.linkOIDCUser(id)
+	if errors.Is(err, errOIDCUnverifiedEmail) || errors.Is(err, errOIDCNoEmail) || errors.Is(err, errOIDCOtherSubject) {
+		api.Fail(c, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to link account"))
+		return
+	}
+	if u.DisabledAt != nil {
+		accountDisabled(c, http.StatusForbidden)
+		return
+	}
+
+	amr := []string{"oidc"}
+	if slices.ContainsFunc(id.AMR, func(m string) bool { return m == "mfa" || m == "otp" || m == "hwk" }) {
+		// the provider already checked a second factor
+		amr = append(amr, "otp")
+	} else if u.TOTPEnabledAt != nil {
+		// it did not, so the account's own TOTP is asked for at
+		// /auth/login/2fa, as after a password
+		challenge, err := h.keys.GenerateChallenge(u.ID, u.Email, amr)
+		if err != nil {
+			api.Abort(c, api.Internal(err, "failed to sign challenge"))
+			return
+		}
+		frag := url.Values{}
+		frag.Set("twoFactorRequired", "true")
+		frag.Set("challengeToken", challenge)
+		h.oidcRedirect(c, frag)
+		return
+	}
+	_ = h.users.RecordLogin(u.ID)
+	body, err := h.newSession(u, amr)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to start session"))
+		return
+	}
+	frag := url.Values{}
+	frag.Set("token", body["token"].(string))
+	frag.Set("refreshToken", body["refreshToken"].(string))
+	frag.Set("expiresAt", body["expiresAt"].(time.Time).Format(time.RFC3339))
+	h.oidcRedirect(c, frag)
+}
+
+// oidcRedirect sends the browser back to the frontend with frag in the URL
+// fragment, which never reaches server logs.
+func (h *Handler) oidcRedirect(c *gin.Context, frag url.Values) {
+	c.Redirect(http.StatusFound, strings.TrimRight(h.cfg.AppURL, "/")+"/login/oidc#"+frag.Encode())
+}
+
+var (
+	errOIDCNoEmail         = errors.New("identity provider did not share an email address")
+	errOIDCUnverifiedEmail = errors.New("identity provider email address is not verified")
+	errOIDCOtherSubject    = errors.New("the account with this email is linked to another identity")
+)
+
+// linkOIDCUser finds the account for id, in order: one already linked to
+// the subject, one with the same (verified) email which then gets linked,
+// or a newly provisioned one. An email match already linked to another
+// subject is refused, so a reassigned address can't take over the account. The role follows the provider's groups
+// whenever any of them is mapped.
+func (h *Handler) linkOIDCUser(id *OIDCIdentity) (*models.User, error) {
+	subject := h.cfg.OIDC.cfg.Issuer + "#" + id.Subject
+	role, mapped := h.cfg.OIDC.RoleFor(id.Groups)
+
+	u, err := h.users.ByOIDCSubject(subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if u == nil {
+		if id.Email == "" {
+			return nil, errOIDCNoEmail
+		}
+		if !id.EmailVerified {
+			return nil, errOIDCUnverifiedEmail
+		}
+		u, err = h.users.ByEmail(id.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if u != nil && u.OIDCSubject != nil && *u.OIDCSubject != subject {
+			log.Printf("⚠️  oidc: %s is linked to %s, refused login as %s", u.Email, *u.OIDCSubject, subject)
+			return nil, errOIDCOtherSubject
+		}
+	}
+
+	now := time.Now()
+	if u == nil {
+		if !mapped {
+			role = h.cfg.OIDC.cfg.DefaultRole
+		}
+		name := id.Name
+		if name == "" {
+			name = id.Email
+		}
+		u = &models.User{Email: id.Email, Name: name, Role: role, OIDCSubject: &subject, EmailVerifiedAt: &now}
+		if err := h.users.Create(u); err != nil {
+			return nil, fmt.Errorf("provision oidc user: %w", err)
+		}
+		log.Printf("✅ Provisioned %s from identity provider as %s", u.Email, u.Role)
+		return u, nil
+	}
+
+	changed := false
+	if u.OIDCSubject == nil {
+		u.OIDCSubject = &subject
+		changed = true
+	}
+	if u.EmailVerifiedAt == nil {
+		u.EmailVerifiedAt = &now
+		changed = true
+	}
+	if mapped && u.Role != role {
+		log.Printf("🔁 Role of %s changed from %s to %s by identity provider groups", u.Email, u.Role, role)
+		u.Role = role
+		changed = true
+	}
+	if changed {
+		if err := h.users.Save(u); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
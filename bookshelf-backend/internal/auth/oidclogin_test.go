@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/internal/users"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestLinkOIDCUser(t *testing.T) {
+	db := testdb.Open(t)
+	h := &Handler{users: users.NewRepository(db), cfg: Config{
+		OIDC: NewOIDCProvider(OIDCConfig{Issuer: "https://idp.test", DefaultRole: models.RoleViewer}),
+	}}
+	other := "https://idp.test#someone-else"
+	linked := &models.User{Email: "linked@mail.com", Name: "Linked", Role: models.RoleViewer, OIDCSubject: &other}
+	plain := &models.User{Email: "plain@mail.com", Name: "Plain", Role: models.RoleViewer}
+	for _, u := range []*models.User{linked, plain} {
+		if err := h.users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an unverified email does not get the account that has it
+	if _, err := h.linkOIDCUser(&OIDCIdentity{Subject: "attacker", Email: "plain@mail.com"}); !errors.Is(err, errOIDCUnverifiedEmail) {
+		t.Fatalf("unverified email of an account: err = %v, want errOIDCUnverifiedEmail", err)
+	}
+	// an email match linked to another subject is refused
+	_, err := h.linkOIDCUser(&OIDCIdentity{Subject: "attacker", Email: "linked@mail.com", EmailVerified: true})
+	if !errors.Is(err, errOIDCOtherSubject) {
+		t.Fatalf("email of an account linked elsewhere: err = %v, want errOIDCOtherSubject", err)
+	}
+	// the linked subject itself still gets in
+	u, err := h.linkOIDCUser(&OIDCIdentity{Subject: "someone-else", Email: "changed@mail.com", EmailVerified: true})
+	if err != nil || u.ID != linked.ID {
+		t.Fatalf("linked subject: (%v, %v), want user %d", u, err, linked.ID)
+	}
+	// an unlinked email match is linked
+	u, err = h.linkOIDCUser(&OIDCIdentity{Subject: "plain", Email: "plain@mail.com", EmailVerified: true})
+	if err != nil || u.ID != plain.ID || u.OIDCSubject == nil || *u.OIDCSubject != "https://idp.test#plain" {
+		t.Fatalf("unlinked email: (%v, %v), want user %d linked", u, err, plain.ID)
+	}
+	if _, err := h.linkOIDCUser(&OIDCIdentity{Subject: "x", Email: "new@mail.com"}); !errors.Is(err, errOIDCUnverifiedEmail) {
+		t.Errorf("unverified email: err = %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+// Permission is a single action a role may be allowed to perform.
+type Permission string
+
+const (
+	PermBooksRead   Permission = "books:read"
+	PermBooksWrite  Permission = "books:write"
+	PermBooksDelete Permission = "books:delete"
+	PermUsersManage Permission = "users:manage"
+)
+
+// rolePermissions is the role → permission table backing RequirePermission.
+// Roles missing from the table have no permissions at all.
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin:  {PermBooksRead, PermBooksWrite, PermBooksDelete, PermUsersManage},
+	models.RoleEditor: {PermBooksRead, PermBooksWrite},
+	models.RoleViewer: {PermBooksRead},
+	// "user" is what /auth/register assigned before roles existed.
+	"user": {PermBooksRead},
+}
+
+// IsValidRole reports whether role is one of the assignable roles.
+func IsValidRole(role string) bool {
+	switch role {
+	case models.RoleAdmin, models.RoleEditor, models.RoleViewer:
+		return true
+	}
+	return false
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// mfaRequired aborts the request when the session still owes the second
+// factor required for its role.
+func mfaRequired(c *gin.Context) bool {
+	if c.GetBool(ctxMFAPending) {
+		api.Abort(c, api.NewError(http.StatusForbidden, "two-factor authentication required for this role").WithCode(api.CodeMFARequired))
+		return true
+	}
+	return false
+}
+
+// RequireRole lets the request through only if the authenticated user has
+// one of the given roles. It must run after AuthRequired.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := GetUserRole(c)
+		if !ok {
+			api.Fail(c, http.StatusUnauthorized, "not authenticated")
+			return
+		}
+		if mfaRequired(c) {
+			return
+		}
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		api.Fail(c, http.StatusForbidden, "insufficient role")
+	}
+}
+
+// RequirePermission lets the request through only if the authenticated
+// user's role grants perm and, for API keys, the key has perm as a scope.
+// It must run after AuthRequired.
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := GetUserRole(c)
+		if !ok {
+			api.Fail(c, http.StatusUnauthorized, "not authenticated")
+			return
+		}
+		if mfaRequired(c) {
+			return
+		}
+		if !HasPermission(role, perm) {
+			api.Fail(c, http.StatusForbidden, "missing permission "+string(perm))
+			return
+		}
+		if scopes, ok := c.Get(ctxScopes); ok && !slices.Contains(scopes.([]string), string(perm)) {
+			api.Fail(c, http.StatusForbidden, "api key lacks scope "+string(perm))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/mail"
+	"github.com/giovannyptr/bookshelf/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const changeEmailTTL = 24 * time.Hour
+
+// currentUser loads the authenticated user or answers 401.
+func (h *Handler) currentUser(c *gin.Context) (*models.User, bool) {
+	uid, _ := GetUserID(c)
+	u, err := h.users.ByID(uid)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return nil, false
+	}
+	return u, true
+}
+
+// checkPassword verifies password for a sensitive change to u. Wrong
+// guesses count towards the login throttle, so this can't be used to
+// brute force a password from a stolen session.
+func (h *Handler) checkPassword(c *gin.Context, u *models.User, password string) bool {
+	ip := c.ClientIP()
+	wait, err := h.throttle.Reserve(u.Email, ip)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check login attempts"))
+		return false
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		if err := h.throttle.Failure(u.Email, ip); err != nil {
+			log.Printf("⚠️  failed to record login failure: %v", err)
+		}
+		api.Fail(c, http.StatusBadRequest, "current password is incorrect")
+		return false
+	}
+	if err := h.throttle.Success(u.Email, ip); err != nil {
+		log.Printf("⚠️  failed to reset login attempts: %v", err)
+	}
+	return true
+}
+
+// me godoc
+// @Summary Current user
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 401 {object} api.ErrorResponse
+// @Router  /auth/me [get]
+func (h *Handler) me(c *gin.Context) {
+	if u, ok := h.currentUser(c); ok {
+		api.OK(c, u)
+	}
+}
+
+type updateMeDTO struct {
+	Name            *string `json:"name"            binding:"omitempty,notblank,max=100" example:"New Name"`
+	Email           *string `json:"email"           binding:"omitempty,email,max=254" example:"new@mail.com"`
+	CurrentPassword string  `json:"currentPassword" example:"12345678"`
+}
+
+// updateMe godoc
+// @Summary Update your profile
+// @Description Changes the name right away. A new email needs the current password (when the account has one) and only replaces the old address once the link mailed to the new one is followed at /auth/confirm-email; until then it is shown as pendingEmail.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body updateMeDTO true "Fields to change"
+// @Success 200 {object} models.User
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Router  /auth/me [patch]
+func (h *Handler) updateMe(c *gin.Context) {
+	var in updateMeDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if in.Name != nil {
+		u.Name = strings.TrimSpace(*in.Name)
+	}
+
+	var newEmail string
+	if in.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*in.Email))
+		switch {
+		case email == u.Email:
+			// changing back cancels a pending change
+			u.PendingEmail = nil
+		case u.PendingEmail != nil && *u.PendingEmail == email:
+			// already pending, just mail the link again
+			newEmail = email
+		default:
+			if u.Password != "" && !h.checkPassword(c, u, in.CurrentPassword) {
+				return
+			}
+			if _, err := h.users.ByEmail(email); err == nil {
+				api.Fail(c, http.StatusConflict, "email already registered")
+				return
+			}
+			u.PendingEmail = &email
+			newEmail = email
+		}
+	}
+
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to update profile"))
+		return
+	}
+	if newEmail != "" {
+		if err := h.sendEmailChange(u, newEmail); err != nil {
+			api.Abort(c, api.Internal(err, "failed to send confirmation email"))
+			return
+		}
+	}
+	api.OK(c, u)
+}
+
+// sendEmailChange mails a confirmation link to the new address and a
+// heads-up to the current one.
+func (h *Handler) sendEmailChange(u *models.User, newEmail string) error {
+	token, err := h.tokens.IssueAction(u.ID, models.PurposeChangeEmail, changeEmailTTL)
+	if err != nil {
+		return err
+	}
+	h.sendMail(mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Bookshelf email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm %s as the new email address of your Bookshelf account by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			u.Name, newEmail, h.link("/confirm-email", token), changeEmailTTL),
+	})
+	h.sendMail(mail.Message{
+		To:      u.Email,
+		Subject: "Your Bookshelf email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address of your Bookshelf account to %s. It changes once the link sent there is opened. If this wasn't you, change your password now.\n",
+			u.Name, newEmail),
+	})
+	return nil
+}
+
+type confirmEmailDTO struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// confirmEmail godoc
+// @Summary Confirm a new email address
+// @Description Consumes the token mailed by PATCH /auth/me and makes the pending address the account email.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body confirmEmailDTO true "Confirmation token"
+// @Success 200 {object} models.User
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /auth/confirm-email [post]
+func (h *Handler) confirmEmail(c *gin.Context) {
+	var in confirmEmailDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	at, err := h.tokens.ConsumeAction(in.Token, models.PurposeChangeEmail)
+	if errors.Is(err, ErrActionInvalid) {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to confirm email"))
+		return
+	}
+	u, err := h.users.ByID(at.UserID)
+	if err != nil || u.PendingEmail == nil {
+		api.Fail(c, http.StatusBadRequest, ErrActionInvalid.Error())
+		return
+	}
+	if _, err := h.users.ByEmail(*u.PendingEmail); err == nil {
+		api.Fail(c, http.StatusConflict, "email already registered")
+		return
+	}
+	now := time.Now()
+	u.Email = *u.PendingEmail
+	u.PendingEmail = nil
+	u.EmailVerifiedAt = &now
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to confirm email"))
+		return
+	}
+	api.OK(c, u)
+}
+
+type changePasswordDTO struct {
+	CurrentPassword string `json:"currentPassword" binding:"required" example:"12345678"`
+	NewPassword     string `json:"newPassword"     binding:"required,password" example:"new-secret-password-1"`
+}
+
+// changePassword godoc
+// @Summary Change your password
+// @Description Ends every session of the user, including the current one, and answers with a fresh token pair for the caller. API keys stay valid.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body changePasswordDTO true "Current and new password"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Router  /auth/me/password [post]
+func (h *Handler) changePassword(c *gin.Context) {
+	var in changePasswordDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if u.Password == "" {
+		api.Fail(c, http.StatusBadRequest, "account has no password, use forgot-password to set one")
+		return
+	}
+	if !h.checkPassword(c, u, in.CurrentPassword) {
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(in.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to hash password"))
+		return
+	}
+	u.Password = string(hash)
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to change password"))
+		return
+	}
+	claims, _ := getClaims(c)
+	if err := h.tokens.RevokeAllForUser(u.ID); err != nil {
+		log.Printf("⚠️  failed to revoke sessions of user %d: %v", u.ID, err)
+	}
+	_ = h.tokens.RevokeAccess(claims.ID, u.ID, claims.ExpiresAt.Time)
+	h.startSession(c, http.StatusOK, u, claims.AMR...)
+}
+
+// recentLogin is how long after logging in an account without a password
+// may still be deleted.
+const recentLogin = 10 * time.Minute
+
+// loggedInRecently reports whether the session of claims was started by a
+// login within recentLogin; refreshing the session doesn't count.
+func loggedInRecently(claims *Claims) bool {
+	return claims != nil && claims.AuthTime != nil && time.Since(claims.AuthTime.Time) <= recentLogin
+}
+
+type deleteMeDTO struct {
+	Password string `json:"password" example:"12345678"`
+}
+
+// deleteMe godoc
+// @Summary Delete your account
+// @Description Needs the current password when the account has one; an account without one (signed up through the identity provider) must have logged in within the last 10 minutes. Sessions, API keys, recovery codes and mailed links are deleted. The user row is kept but anonymized (email, name, password, 2FA and identity provider link are cleared) so ids referenced elsewhere keep resolving; the account can't be used or restored afterwards. The last active admin can't delete their account.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body deleteMeDTO false "Current password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse "no password and no recent login"
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Router  /auth/me [delete]
+func (h *Handler) deleteMe(c *gin.Context) {
+	var in deleteMeDTO
+	if c.Request.ContentLength != 0 {
+		if !api.BindJSON(c, &in) {
+			return
+		}
+	}
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	// without a password to ask for, the login itself must be recent
+	claims, _ := getClaims(c)
+	if u.Password == "" && !loggedInRecently(claims) {
+		api.Fail(c, http.StatusForbidden, "log in again to delete your account")
+		return
+	}
+	if u.Password != "" && !h.checkPassword(c, u, in.Password) {
+		return
+	}
+	if u.Role == models.RoleAdmin {
+		n, err := h.users.CountActiveAdmins()
+		if err != nil {
+			api.Abort(c, api.Internal(err, "failed to delete account"))
+			return
+		}
+		if n <= 1 {
+			api.Fail(c, http.StatusConflict, "the last admin cannot delete their account")
+			return
+		}
+	}
+	if err := h.users.Anonymize(u.ID); err != nil {
+		api.Abort(c, api.Internal(err, "failed to delete account"))
+		return
+	}
+	_ = h.tokens.RevokeAccess(claims.ID, u.ID, claims.ExpiresAt.Time)
+	log.Printf("🗑️  User %d deleted their account", u.ID)
+	api.OK(c, gin.H{"message": "account deleted"})
+}
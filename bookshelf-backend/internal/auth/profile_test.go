@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/internal/users"
+	"github.com/giovannyptr/bookshelf/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAccessTokenAuthTime(t *testing.T) {
+	km := NewKeyManager("bookshelf", 0)
+	if err := km.Rotate("k1", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	login := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tok, _, err := km.GenerateToken(1, "a@mail.com", models.RoleViewer, "sid", []string{"pwd"}, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := km.ParseToken(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(login) {
+		t.Errorf("auth_time = %v, want %v", claims.AuthTime, login)
+	}
+	if claims.IssuedAt.Time.Equal(login) {
+		t.Error("iat copied from auth_time")
+	}
+
+	tok, _, err = km.GenerateToken(1, "a@mail.com", models.RoleViewer, "sid", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := km.ParseToken(tok); err != nil || claims.AuthTime != nil {
+		t.Errorf("unknown login time: auth_time = %v, %v", claims.AuthTime, err)
+	}
+}
+
+func TestLoggedInRecently(t *testing.T) {
+	at := func(d time.Duration) *Claims { return &Claims{AuthTime: jwt.NewNumericDate(time.Now().Add(-d))} }
+	for _, tt := range []struct {
+		name   string
+		claims *Claims
+		want   bool
+	}{
+		{"just now", at(0), true},
+		{"within the window", at(recentLogin - time.Minute), true},
+		{"too long ago", at(recentLogin + time.Minute), false},
+		{"no auth_time", &Claims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Now())}}, false},
+		{"no claims", nil, false},
+	} {
+		if got := loggedInRecently(tt.claims); got != tt.want {
+			t.Errorf("%s: loggedInRecently = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRotateKeepsAuthTime(t *testing.T) {
+	tokens := NewTokenRepository(testdb.Open(t))
+	login := time.Now().Add(-time.Hour)
+	raw, _, err := tokens.IssueRefresh(1, "family", []string{"pwd"}, login, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rt, err := tokens.Rotate(raw, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.AuthTime == nil || !rt.AuthTime.Equal(login) {
+		t.Errorf("rotated auth_time = %v, want %v", rt.AuthTime, login)
+	}
+}
+
+func TestDeleteMeWithoutPasswordNeedsRecentLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	h := &Handler{users: users.NewRepository(db), tokens: NewTokenRepository(db)}
+	u := &models.User{Email: "sso@mail.com", Name: "SSO", Role: models.RoleViewer}
+	if err := h.users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	deleteMe := func(authTime time.Time) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("DELETE", "/auth/me", nil)
+		c.Set(ctxUserID, u.ID)
+		c.Set(ctxClaims, &Claims{UserID: u.ID, AuthTime: jwt.NewNumericDate(authTime), RegisteredClaims: jwt.RegisteredClaims{
+			ID: "jti", IssuedAt: jwt.NewNumericDate(time.Now()), ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}})
+		h.deleteMe(c)
+		return w.Code
+	}
+
+	// a session kept alive by refreshing is not enough
+	if code := deleteMe(time.Now().Add(-time.Hour)); code != http.StatusForbidden {
+		t.Fatalf("delete long after login = %d, want 403", code)
+	}
+	if stored, err := h.users.ByID(u.ID); err != nil || stored.AnonymizedAt != nil {
+		t.Fatalf("account after refused delete: (%+v, %v)", stored, err)
+	}
+	if code := deleteMe(time.Now()); code != http.StatusOK {
+		t.Fatalf("delete right after login = %d, want 200", code)
+	}
+	if stored, err := h.users.ByID(u.ID); err != nil || stored.AnonymizedAt == nil {
+		t.Errorf("account after delete: (%+v, %v), want anonymized", stored, err)
+	}
+}
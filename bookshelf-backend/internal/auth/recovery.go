@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/mail"
+	"github.com/giovannyptr/bookshelf/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	resetTokenTTL  = time.Hour
+	verifyTokenTTL = 48 * time.Hour
+)
+
+// link builds a frontend URL carrying a mailed token.
+func (h *Handler) link(path, token string) string {
+	return strings.TrimRight(h.cfg.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail delivers in the background so response times don't reveal
+// whether an address has an account.
+func (h *Handler) sendMail(m mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := h.mailer.Send(ctx, m); err != nil {
+			log.Printf("⚠️  failed to send %q to %s: %v", m.Subject, m.To, err)
+		}
+	}()
+}
+
+func (h *Handler) sendVerification(u *models.User) error {
+	token, err := h.tokens.IssueAction(u.ID, models.PurposeVerifyEmail, verifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	h.sendMail(mail.Message{
+		To:      u.Email,
+		Subject: "Confirm your Bookshelf email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			u.Name, h.link("/verify-email", token), verifyTokenTTL),
+	})
+	return nil
+}
+
+func (h *Handler) sendPasswordReset(u *models.User) error {
+	token, err := h.tokens.IssueAction(u.ID, models.PurposePasswordReset, resetTokenTTL)
+	if err != nil {
+		return err
+	}
+	h.sendMail(mail.Message{
+		To:      u.Email,
+		Subject: "Reset your Bookshelf password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Bookshelf account. If that was you, open this link:\n\n%s\n\nThe link expires in %s. If you did not ask for this, ignore this email.\n",
+			u.Name, h.link("/reset-password", token), resetTokenTTL),
+	})
+	return nil
+}
+
+type forgotPasswordDTO struct {
+	Email string `json:"email" binding:"required,email" example:"user@mail.com"`
+}
+
+// forgotPassword godoc
+// @Summary Request a password reset link
+// @Description Always answers 200 so the endpoint can't be used to probe for accounts.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body forgotPasswordDTO true "Account email"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.FieldErrorResponse
+// @Router  /auth/forgot-password [post]
+func (h *Handler) forgotPassword(c *gin.Context) {
+	var in forgotPasswordDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	if u, err := h.users.ByEmail(strings.ToLower(strings.TrimSpace(in.Email))); err == nil {
+		if err := h.sendPasswordReset(u); err != nil {
+			log.Printf("⚠️  failed to issue reset token for user %d: %v", u.ID, err)
+		}
+	}
+	api.OK(c, gin.H{"message": "if the account exists, a reset link has been sent"})
+}
+
+type resetPasswordDTO struct {
+	Token    string `json:"token"    binding:"required"`
+	Password string `json:"password" binding:"required,password" example:"new-secret-password-1"`
+}
+
+// resetPassword godoc
+// @Summary Set a new password with a reset token
+// @Description Consumes the token and ends every existing session of the user.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body resetPasswordDTO true "Token and new password"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.FieldErrorResponse
+// @Router  /auth/reset-password [post]
+func (h *Handler) resetPassword(c *gin.Context) {
+	var in resetPasswordDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	at, err := h.tokens.ConsumeAction(in.Token, models.PurposePasswordReset)
+	if errors.Is(err, ErrActionInvalid) {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to reset password"))
+		return
+	}
+	u, err := h.users.ByID(at.UserID)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, ErrActionInvalid.Error())
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to hash password"))
+		return
+	}
+	u.Password = string(hash)
+	if u.EmailVerifiedAt == nil {
+		// receiving the reset mail proves control of the address
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to reset password"))
+		return
+	}
+	if err := h.tokens.RevokeAllForUser(u.ID); err != nil {
+		log.Printf("⚠️  failed to revoke sessions of user %d: %v", u.ID, err)
+	}
+	api.OK(c, gin.H{"message": "password updated"})
+}
+
+type verifyEmailDTO struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// verifyEmail godoc
+// @Summary Confirm an email address
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body verifyEmailDTO true "Verification token"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.ErrorResponse
+// @Router  /auth/verify-email [post]
+func (h *Handler) verifyEmail(c *gin.Context) {
+	var in verifyEmailDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	at, err := h.tokens.ConsumeAction(in.Token, models.PurposeVerifyEmail)
+	if errors.Is(err, ErrActionInvalid) {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to verify email"))
+		return
+	}
+	u, err := h.users.ByID(at.UserID)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, ErrActionInvalid.Error())
+		return
+	}
+	if u.EmailVerifiedAt == nil {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+		if err := h.users.Save(u); err != nil {
+			api.Abort(c, api.Internal(err, "failed to verify email"))
+			return
+		}
+	}
+	api.OK(c, gin.H{"message": "email verified", "emailVerifiedAt": u.EmailVerifiedAt})
+}
+
+// resendVerification godoc
+// @Summary Send a new email verification link
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]any
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /auth/verify-email/resend [post]
+func (h *Handler) resendVerification(c *gin.Context) {
+	uid, _ := GetUserID(c)
+	u, err := h.users.ByID(uid)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.EmailVerifiedAt != nil {
+		api.Fail(c, http.StatusConflict, "email already verified")
+		return
+	}
+	if err := h.sendVerification(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to send verification email"))
+		return
+	}
+	api.OK(c, gin.H{"message": "verification email sent"})
+}
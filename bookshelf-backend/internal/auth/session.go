@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+	"github.com/google/uuid"
+)
+
+// startSession opens a new refresh token family for u and responds with an
+// access/refresh token pair. amr records how the user authenticated.
+func (h *Handler) startSession(c *gin.Context, status int, u *models.User, amr ...string) {
+	body, err := h.newSession(u, amr)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to start session"))
+		return
+	}
+	c.JSON(status, body)
+}
+
+func (h *Handler) newSession(u *models.User, amr []string) (gin.H, error) {
+	sid := uuid.NewString()
+	now := time.Now()
+	refresh, _, err := h.tokens.IssueRefresh(u.ID, sid, amr, now, refreshTTL())
+	if err != nil {
+		return nil, err
+	}
+	return h.tokenBody(u, sid, amr, now, refresh)
+}
+
+// tokenBody signs an access token and builds the login/refresh response.
+func (h *Handler) tokenBody(u *models.User, sid string, amr []string, authTime time.Time, refresh string) (gin.H, error) {
+	token, claims, err := h.keys.GenerateToken(u.ID, u.Email, u.Role, sid, amr, authTime)
+	if err != nil {
+		return nil, err
+	}
+	body := gin.H{
+		"token":        token,
+		"expiresAt":    claims.ExpiresAt.Time,
+		"refreshToken": refresh,
+		"user":         gin.H{"id": u.ID, "email": u.Email, "name": u.Name, "role": u.Role},
+	}
+	if h.mfaPending(u.Role, claims) {
+		body["twoFactorSetupRequired"] = true
+	}
+	return body, nil
+}
+
+type refreshDTO struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// refresh godoc
+// @Summary Exchange a refresh token for a new token pair
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body refreshDTO true "Refresh payload"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Router  /auth/refresh [post]
+func (h *Handler) refresh(c *gin.Context) {
+	var in refreshDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	next, rt, err := h.tokens.Rotate(in.RefreshToken, refreshTTL())
+	if errors.Is(err, ErrRefreshInvalid) || errors.Is(err, ErrRefreshReused) {
+		api.Fail(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to refresh session"))
+		return
+	}
+	u, err := h.users.ByID(rt.UserID)
+	if err != nil {
+		_ = h.tokens.RevokeFamily(rt.FamilyID)
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.DisabledAt != nil {
+		_ = h.tokens.RevokeFamily(rt.FamilyID)
+		accountDisabled(c, http.StatusUnauthorized)
+		return
+	}
+	var amr []string
+	if rt.AMR != "" {
+		amr = strings.Split(rt.AMR, ",")
+	}
+	var authTime time.Time
+	if rt.AuthTime != nil {
+		authTime = *rt.AuthTime
+	}
+	body, err := h.tokenBody(u, rt.FamilyID, amr, authTime, next)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to sign token"))
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// logout godoc
+// @Summary Log out the current session
+// @Description Revokes the access token used for the call and its refresh token family. With all=true every session of the user is ended.
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Param   all query bool false "End all sessions of the user"
+// @Success 200 {object} map[string]any
+// @Failure 401 {object} api.ErrorResponse
+// @Router  /auth/logout [post]
+func (h *Handler) logout(c *gin.Context) {
+	claims, _ := getClaims(c)
+	if err := h.tokens.RevokeAccess(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		api.Abort(c, api.Internal(err, "failed to revoke token"))
+		return
+	}
+	var err error
+	if c.Query("all") == "true" {
+		err = h.tokens.RevokeAllForUser(claims.UserID)
+	} else if claims.SessionID != "" {
+		err = h.tokens.RevokeFamily(claims.SessionID)
+	}
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to end session"))
+		return
+	}
+	api.OK(c, gin.H{"message": "logged out"})
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Attempts is the failure record kept per throttling key.
+type Attempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// AttemptStore persists failure counters. Reserve must be atomic so
+// concurrent guesses can't all pass the same check.
+type AttemptStore interface {
+	Get(key string) (Attempts, error)
+	// Reserve checks key against p and, unless a wait is due, records one
+	// failure at now in the same step, first forgetting failures older
+	// than p.Window. It returns the wait, zero if the failure was recorded.
+	Reserve(key string, p ThrottlePolicy, now time.Time) (time.Duration, error)
+	// Release takes back one recorded failure.
+	Release(key string) error
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// ThrottlePolicy describes how one class of key (account or IP) is limited.
+type ThrottlePolicy struct {
+	FreeAttempts int           // failures allowed before any delay
+	BaseDelay    time.Duration // delay after the first counted failure, doubled each time
+	MaxDelay     time.Duration
+	LockAfter    int           // failures that trigger a lockout
+	LockFor      time.Duration // lockout length
+	Window       time.Duration // failures older than this are forgotten
+}
+
+var (
+	accountPolicy = ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Minute, LockAfter: 10, LockFor: 15 * time.Minute, Window: time.Hour}
+	ipPolicy      = ThrottlePolicy{FreeAttempts: 20, BaseDelay: time.Second, MaxDelay: 5 * time.Minute, LockAfter: 100, LockFor: 15 * time.Minute, Window: time.Hour}
+)
+
+// fail is a with one more failure at now, forgetting older ones outside
+// window.
+func (a Attempts) fail(now time.Time, window time.Duration) Attempts {
+	if now.Sub(a.LastFailureAt) > window {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	return a
+}
+
+// retryAfter is how long the key must wait before the next attempt.
+func (p ThrottlePolicy) retryAfter(a Attempts, now time.Time) time.Duration {
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+	if a.Failures <= p.FreeAttempts || now.Sub(a.LastFailureAt) > p.Window {
+		return 0
+	}
+	exp := float64(a.Failures - p.FreeAttempts - 1)
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, exp))
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	if wait := a.LastFailureAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Throttler applies exponential backoff and lockout per account and per
+// client IP for login attempts.
+type Throttler struct {
+	store AttemptStore
+	now   func() time.Time
+}
+
+func NewThrottler(store AttemptStore) *Throttler {
+	return &Throttler{store: store, now: time.Now}
+}
+
+func accountKey(email string) string { return "acct:" + email }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+type throttleKey struct {
+	key    string
+	policy ThrottlePolicy
+}
+
+func throttleKeys(email, ip string) []throttleKey {
+	return []throttleKey{{accountKey(email), accountPolicy}, {ipKey(ip), ipPolicy}}
+}
+
+// Reserve returns how long the caller must wait before trying to log in as
+// email from ip; zero means go ahead. An attempt that may go ahead is
+// counted as a failure straight away, before the password is compared, so
+// parallel guesses can't all pass the same check; Success takes it back.
+func (t *Throttler) Reserve(email, ip string) (time.Duration, error) {
+	now := t.now()
+	var reserved []string
+	for _, k := range throttleKeys(email, ip) {
+		wait, err := t.store.Reserve(k.key, k.policy, now)
+		if err == nil && wait == 0 {
+			reserved = append(reserved, k.key)
+			continue
+		}
+		// a turned away attempt counts against neither key
+		for _, key := range reserved {
+			if err := t.store.Release(key); err != nil {
+				log.Printf("⚠️  failed to release login attempt: %v", err)
+			}
+		}
+		return wait, err
+	}
+	return 0, nil
+}
+
+// Failure confirms a reserved attempt failed, locking the keys once they
+// pass their policy's threshold.
+func (t *Throttler) Failure(email, ip string) error {
+	now := t.now()
+	for _, k := range throttleKeys(email, ip) {
+		a, err := t.store.Get(k.key)
+		if err != nil {
+			return err
+		}
+		if a.Failures >= k.policy.LockAfter && !now.Before(a.LockedUntil) {
+			if err := t.store.Lock(k.key, now.Add(k.policy.LockFor)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Success clears the account's counter and takes back the IP's reserved
+// attempt. The rest of the IP counter is left alone so a single valid
+// account can't be used to reset it.
+func (t *Throttler) Success(email, ip string) error {
+	if err := t.store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return t.store.Release(ipKey(ip))
+}
+
+// ---------- in-memory store ----------
+
+// MemoryAttemptStore keeps counters in process memory. Counters are lost on
+// restart and not shared between replicas.
+type MemoryAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]Attempts
+	ops     int
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{entries: map[string]Attempts{}}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key], nil
+}
+
+func (s *MemoryAttemptStore) Reserve(key string, p ThrottlePolicy, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops++
+	if s.ops%1000 == 0 {
+		s.sweep(now, p.Window)
+	}
+	a := s.entries[key]
+	if wait := p.retryAfter(a, now); wait > 0 {
+		return wait, nil
+	}
+	s.entries[key] = a.fail(now, p.Window)
+	return 0, nil
+}
+
+func (s *MemoryAttemptStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.entries[key]; ok && a.Failures > 0 {
+		a.Failures--
+		s.entries[key] = a
+	}
+	return nil
+}
+
+// sweep drops entries that are neither locked nor inside the window.
+func (s *MemoryAttemptStore) sweep(now time.Time, window time.Duration) {
+	for k, a := range s.entries {
+		if now.Sub(a.LastFailureAt) > window && !now.Before(a.LockedUntil) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *MemoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.entries[key]
+	a.LockedUntil = until
+	s.entries[key] = a
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// ---------- postgres store ----------
+
+// DBAttemptStore keeps counters in the login_attempts table so every
+// replica sees the same state.
+type DBAttemptStore struct{ db *gorm.DB }
+
+func NewDBAttemptStore(db *gorm.DB) *DBAttemptStore { return &DBAttemptStore{db: db} }
+
+func toAttempts(m models.LoginAttempt) Attempts {
+	a := Attempts{Failures: m.Failures, LastFailureAt: m.LastFailureAt}
+	if m.LockedUntil != nil {
+		a.LockedUntil = *m.LockedUntil
+	}
+	return a
+}
+
+func (s *DBAttemptStore) Get(key string) (Attempts, error) {
+	var rows []models.LoginAttempt
+	if err := s.db.Where("key = ?", key).Limit(1).Find(&rows).Error; err != nil {
+		return Attempts{}, err
+	}
+	if len(rows) == 0 {
+		return Attempts{}, nil
+	}
+	return toAttempts(rows[0]), nil
+}
+
+// Reserve locks the key's row for the check, so concurrent attempts on
+// one key take turns.
+func (s *DBAttemptStore) Reserve(key string, p ThrottlePolicy, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// make sure there is a row to lock
+		if err := tx.Exec(`
+			INSERT INTO login_attempts (key, failures, last_failure_at, updated_at)
+			VALUES (?, 0, ?, ?)
+			ON CONFLICT (key) DO NOTHING`, key, time.Time{}, now).Error; err != nil {
+			return err
+		}
+		var m models.LoginAttempt
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Take(&m).Error; err != nil {
+			return err
+		}
+		a := toAttempts(m)
+		if wait = p.retryAfter(a, now); wait > 0 {
+			return nil
+		}
+		a = a.fail(now, p.Window)
+		return tx.Model(&models.LoginAttempt{}).Where("key = ?", key).
+			Updates(map[string]any{"failures": a.Failures, "last_failure_at": a.LastFailureAt, "updated_at": now}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reserve login attempt: %w", err)
+	}
+	return wait, nil
+}
+
+func (s *DBAttemptStore) Release(key string) error {
+	return s.db.Model(&models.LoginAttempt{}).Where("key = ? AND failures > 0", key).
+		Updates(map[string]any{"failures": gorm.Expr("failures - 1"), "updated_at": time.Now()}).Error
+}
+
+func (s *DBAttemptStore) Lock(key string, until time.Time) error {
+	return s.db.Model(&models.LoginAttempt{}).Where("key = ?", key).
+		Updates(map[string]any{"locked_until": until, "updated_at": time.Now()}).Error
+}
+
+func (s *DBAttemptStore) Reset(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
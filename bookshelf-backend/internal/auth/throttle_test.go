@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+)
+
+func TestRetryAfter(t *testing.T) {
+	p := ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, LockAfter: 10, LockFor: 15 * time.Minute, Window: time.Hour}
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name string
+		a    Attempts
+		want time.Duration
+	}{
+		{"no failures", Attempts{}, 0},
+		{"free attempts", Attempts{Failures: 3, LastFailureAt: now}, 0},
+		{"first counted failure", Attempts{Failures: 4, LastFailureAt: now}, time.Second},
+		{"doubles", Attempts{Failures: 5, LastFailureAt: now}, 2 * time.Second},
+		{"doubles again", Attempts{Failures: 7, LastFailureAt: now}, 8 * time.Second},
+		{"partly waited", Attempts{Failures: 5, LastFailureAt: now.Add(-1500 * time.Millisecond)}, 500 * time.Millisecond},
+		{"fully waited", Attempts{Failures: 5, LastFailureAt: now.Add(-2 * time.Second)}, 0},
+		{"capped", Attempts{Failures: 30, LastFailureAt: now}, time.Minute},
+		{"huge count does not overflow", Attempts{Failures: 5000, LastFailureAt: now}, time.Minute},
+		{"outside window", Attempts{Failures: 9, LastFailureAt: now.Add(-time.Hour - time.Second)}, 0},
+		{"locked", Attempts{Failures: 10, LastFailureAt: now, LockedUntil: now.Add(10 * time.Minute)}, 10 * time.Minute},
+		{"lock outlives window", Attempts{Failures: 10, LastFailureAt: now.Add(-2 * time.Hour), LockedUntil: now.Add(time.Minute)}, time.Minute},
+		{"lock expired", Attempts{Failures: 2, LastFailureAt: now.Add(-time.Hour), LockedUntil: now.Add(-time.Second)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.retryAfter(tt.a, now); got != tt.want {
+				t.Errorf("retryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	th := NewThrottler(NewMemoryAttemptStore())
+	th.now = func() time.Time { return now }
+	const email, ip = "a@mail.com", "10.0.0.1"
+
+	fail := func() {
+		t.Helper()
+		if wait, err := th.Reserve(email, ip); err != nil || wait != 0 {
+			t.Fatalf("Reserve = (%v, %v), want go ahead", wait, err)
+		}
+		if err := th.Failure(email, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range accountPolicy.FreeAttempts + 1 {
+		fail()
+	}
+	if wait, _ := th.Reserve(email, ip); wait != accountPolicy.BaseDelay {
+		t.Fatalf("wait after the free attempts = %v, want %v", wait, accountPolicy.BaseDelay)
+	}
+	// a turned away attempt is not counted
+	now = now.Add(accountPolicy.BaseDelay)
+	if wait, _ := th.Reserve(email, ip); wait != 0 {
+		t.Fatalf("wait after waiting = %v, want 0", wait)
+	}
+	if err := th.Success(email, ip); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := th.store.Get(accountKey(email)); a.Failures != 0 {
+		t.Errorf("account failures after success = %d, want 0", a.Failures)
+	}
+	if a, _ := th.store.Get(ipKey(ip)); a.Failures != accountPolicy.FreeAttempts+1 {
+		t.Errorf("ip failures after success = %d, want %d", a.Failures, accountPolicy.FreeAttempts+1)
+	}
+}
+
+func TestThrottlerLocks(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	th := NewThrottler(NewMemoryAttemptStore())
+	th.now = func() time.Time { return now }
+	for i := range accountPolicy.LockAfter {
+		wait, err := th.Reserve("a@mail.com", "10.0.0.1")
+		if err != nil || wait != 0 {
+			t.Fatalf("attempt %d: Reserve = (%v, %v)", i+1, wait, err)
+		}
+		if err := th.Failure("a@mail.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(accountPolicy.MaxDelay)
+	}
+	if wait, _ := th.Reserve("a@mail.com", "10.0.0.1"); wait != accountPolicy.LockFor-accountPolicy.MaxDelay {
+		t.Errorf("wait = %v, want the rest of the lockout", wait)
+	}
+}
+
+// concurrentReservations fires n parallel reservations for one account
+// from fresh IPs and counts those let through.
+func concurrentReservations(t *testing.T, th *Throttler, n int) int {
+	t.Helper()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := th.Reserve("a@mail.com", fmt.Sprintf("10.0.1.%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed
+}
+
+func TestThrottlerReservesAtomically(t *testing.T) {
+	th := NewThrottler(NewMemoryAttemptStore())
+	// the free attempts and the first delayed one
+	if got, want := concurrentReservations(t, th, 20), accountPolicy.FreeAttempts+1; got != want {
+		t.Errorf("%d parallel attempts let through, want %d", got, want)
+	}
+}
+
+func TestDBAttemptStoreReservesAtomically(t *testing.T) {
+	th := NewThrottler(NewDBAttemptStore(testdb.Open(t)))
+	if got, want := concurrentReservations(t, th, 20), accountPolicy.FreeAttempts+1; got != want {
+		t.Errorf("%d parallel attempts let through, want %d", got, want)
+	}
+}
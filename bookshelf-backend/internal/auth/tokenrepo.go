@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRefreshInvalid = errors.New("invalid refresh token")
+	ErrRefreshReused  = errors.New("refresh token reuse detected")
+	ErrActionInvalid  = errors.New("invalid or expired token")
+)
+
+// TokenRepository persists refresh tokens and revoked access tokens.
+type TokenRepository struct{ db *gorm.DB }
+
+func NewTokenRepository(db *gorm.DB) *TokenRepository { return &TokenRepository{db: db} }
+
+// randomToken returns n random bytes encoded as unpadded base64url.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefresh creates a refresh token for uid in the given family and
+// returns the raw value, which is never stored. amr lists how the user
+// authenticated and authTime when; both are copied to every rotated token.
+func (r *TokenRepository) IssueRefresh(uid uint, familyID string, amr []string, authTime time.Time, ttl time.Duration) (string, *models.RefreshToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	rt := models.RefreshToken{
+		UserID:    uid,
+		FamilyID:  familyID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+		AMR:       strings.Join(amr, ","),
+		AuthTime:  &authTime,
+	}
+	if err := r.db.Create(&rt).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, &rt, nil
+}
+
+// Rotate consumes a raw refresh token and issues its replacement in the same
+// family. Presenting an already-consumed token revokes the whole family,
+// since that means the token was stolen or replayed.
+func (r *TokenRepository) Rotate(raw string, ttl time.Duration) (string, *models.RefreshToken, error) {
+	var next string
+	var nextRT *models.RefreshToken
+	var cur models.RefreshToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", hashToken(raw)).First(&cur).Error; err != nil {
+			return ErrRefreshInvalid
+		}
+		if cur.RevokedAt != nil {
+			return ErrRefreshReused
+		}
+		if time.Now().After(cur.ExpiresAt) {
+			return ErrRefreshInvalid
+		}
+
+		now := time.Now()
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", cur.ID).
+			Update("revoked_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// lost a race with a concurrent refresh of the same token
+			return ErrRefreshReused
+		}
+
+		v, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+		rt := models.RefreshToken{
+			UserID:    cur.UserID,
+			FamilyID:  cur.FamilyID,
+			TokenHash: hashToken(v),
+			ExpiresAt: now.Add(ttl),
+			AMR:       cur.AMR,
+			AuthTime:  cur.AuthTime,
+		}
+		if err := tx.Create(&rt).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&cur).Update("replaced_by_id", rt.ID).Error; err != nil {
+			return err
+		}
+		next, nextRT = v, &rt
+		return nil
+	})
+	if errors.Is(err, ErrRefreshReused) {
+		// revoke outside the rolled-back transaction so it sticks
+		if rerr := revokeFamily(r.db, cur.FamilyID); rerr != nil {
+			return "", nil, rerr
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return next, nextRT, nil
+}
+
+func revokeFamily(tx *gorm.DB, familyID string) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeFamily ends a session: every refresh token in the family stops
+// working and access tokens carrying it as "sid" are rejected.
+func (r *TokenRepository) RevokeFamily(familyID string) error {
+	return revokeFamily(r.db, familyID)
+}
+
+// RevokeAllForUser ends every session of uid.
+func (r *TokenRepository) RevokeAllForUser(uid uint) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", uid).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAccess deny-lists a single access token until it would expire anyway.
+func (r *TokenRepository) RevokeAccess(jti string, uid uint, expiresAt time.Time) error {
+	// opportunistic cleanup of entries that no longer matter
+	r.db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+	return r.db.Save(&models.RevokedToken{JTI: jti, UserID: uid, ExpiresAt: expiresAt}).Error
+}
+
+// IsRevoked reports whether an access token with the given jti and session
+// id must be rejected, either because it was deny-listed or because its
+// session has been logged out.
+func (r *TokenRepository) IsRevoked(jti, familyID string) (bool, error) {
+	var n int64
+	if err := r.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&n).Error; err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	if familyID == "" {
+		return false, nil
+	}
+	if err := r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Count(&n).Error; err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+// IssueAction creates a single-use token for purpose and voids any earlier
+// unused one, so only the most recently mailed link works.
+func (r *TokenRepository) IssueAction(uid uint, purpose string, ttl time.Duration) (string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ActionToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", uid, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ActionToken{
+			UserID:    uid,
+			Purpose:   purpose,
+			TokenHash: hashToken(raw),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ConsumeAction marks the token as used and returns it. It fails with
+// ErrActionInvalid for unknown, expired, already used or wrong-purpose tokens.
+func (r *TokenRepository) ConsumeAction(raw, purpose string) (*models.ActionToken, error) {
+	var at models.ActionToken
+	if err := r.db.Where("token_hash = ? AND purpose = ?", hashToken(raw), purpose).First(&at).Error; err != nil {
+		return nil, ErrActionInvalid
+	}
+	if at.UsedAt != nil || time.Now().After(at.ExpiresAt) {
+		return nil, ErrActionInvalid
+	}
+	res := r.db.Model(&models.ActionToken{}).
+		Where("id = ? AND used_at IS NULL", at.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrActionInvalid
+	}
+	return &at, nil
+}
+
+// ReplaceRecoveryCodes discards uid's recovery codes and stores hashes of
+// the given ones.
+func (r *TokenRepository) ReplaceRecoveryCodes(uid uint, codes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", uid).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		rows := make([]models.RecoveryCode, len(codes))
+		for i, c := range codes {
+			rows[i] = models.RecoveryCode{UserID: uid, CodeHash: hashToken(c)}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// UseRecoveryCode burns one unused recovery code of uid and reports whether
+// it matched.
+func (r *TokenRepository) UseRecoveryCode(uid uint, code string) (bool, error) {
+	res := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", uid, hashToken(code)).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
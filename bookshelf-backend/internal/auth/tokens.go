@@ -6,47 +6,110 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Claims struct {
 	UserID uint   `json:"uid"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// SessionID ties the access token to its refresh token family so that
+	// logging out the session also invalidates the access token.
+	SessionID string `json:"sid,omitempty"`
+	// Use separates access tokens from 2FA login challenges, which are
+	// signed with the same keys but must never authorize a request.
+	Use string `json:"use"`
+	// AMR lists the authentication methods (RFC 8176), e.g. "pwd", "otp".
+	AMR []string `json:"amr,omitempty"`
+	// AuthTime is when the user logged in to start the session; unlike
+	// iat it stays the same across refreshes.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func secret() []byte { return []byte(os.Getenv("JWT_SECRET")) }
+const (
+	useAccess    = "access"
+	useChallenge = "2fa_challenge"
+)
+
+// challengeTTL bounds the time between the password and the TOTP step.
+const challengeTTL = 5 * time.Minute
+
+func (c *Claims) hasAMR(method string) bool {
+	for _, m := range c.AMR {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
 
-func expiresAt() time.Time {
-	h := 72
-	if v := os.Getenv("JWT_EXPIRES_HOURS"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			h = n
+func durationEnv(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
 		}
 	}
-	return time.Now().Add(time.Duration(h) * time.Hour)
+	return def
+}
+
+// accessTTL is deliberately short; clients renew through /auth/refresh.
+func accessTTL() time.Duration { return durationEnv("JWT_ACCESS_TTL", 15*time.Minute) }
+
+func refreshTTL() time.Duration { return durationEnv("JWT_REFRESH_TTL", 30*24*time.Hour) }
+
+// GenerateToken signs an access token for the user in session sid, who
+// logged in at authTime (zero if unknown). Every token gets a unique jti
+// so it can be revoked individually.
+func (m *KeyManager) GenerateToken(uid uint, email, role, sid string, amr []string, authTime time.Time) (string, *Claims, error) {
+	return m.sign(useAccess, uid, email, role, sid, amr, authTime, accessTTL())
 }
 
-func GenerateToken(uid uint, email, role string) (string, error) {
+// GenerateChallenge signs the short-lived token returned when the first
+// factor, named in amr, was right but a TOTP code is still needed.
+func (m *KeyManager) GenerateChallenge(uid uint, email string, amr []string) (string, error) {
+	s, _, err := m.sign(useChallenge, uid, email, "", "", amr, time.Time{}, challengeTTL)
+	return s, err
+}
+
+func (m *KeyManager) sign(use string, uid uint, email, role, sid string, amr []string, authTime time.Time, ttl time.Duration) (string, *Claims, error) {
+	now := time.Now()
 	claims := Claims{
-		UserID: uid, Email: email, Role: role,
+		UserID: uid, Email: email, Role: role, SessionID: sid, Use: use, AMR: amr,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt()),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			Issuer:    m.issuer,
+			Subject:   strconv.FormatUint(uint64(uid), 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString(secret())
+	if !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+	s, err := m.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, &claims, nil
+}
+
+// ParseToken verifies an access token.
+func (m *KeyManager) ParseToken(tokenStr string) (*Claims, error) {
+	return m.parse(tokenStr, useAccess)
+}
+
+// ParseChallenge verifies a 2FA login challenge token.
+func (m *KeyManager) ParseChallenge(tokenStr string) (*Claims, error) {
+	return m.parse(tokenStr, useChallenge)
 }
 
-func ParseToken(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return secret(), nil
-	})
+func (m *KeyManager) parse(tokenStr, use string) (*Claims, error) {
+	token, err := m.Parse(tokenStr, &Claims{})
 	if err != nil {
 		return nil, err
 	}
-	if c, ok := token.Claims.(*Claims); ok && token.Valid {
+	if c, ok := token.Claims.(*Claims); ok && token.Valid && c.Use == use {
 		return c, nil
 	}
 	return nil, jwt.ErrTokenInvalidClaims
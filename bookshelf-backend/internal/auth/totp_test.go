@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/internal/users"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+// rfcKey is the SHA-1 key of RFC 4226 Appendix D and RFC 6238 Appendix B.
+var rfcKey = []byte("12345678901234567890")
+
+// rfcSecret is rfcKey in base32, as users store it.
+var rfcSecret = b32.EncodeToString(rfcKey)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226 Appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(rfcKey, uint64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1. The RFC lists 8 digit codes; with 6
+	// digits the code is their last six.
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		code := tt.rfc[2:]
+		step, ok := ValidateTOTP(rfcSecret, code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("t=%d: code %s rejected", tt.unix, code)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("t=%d: step = %d, want %d", tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	at := func(s int64) string { return hotp(rfcKey, uint64(s)) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		ok       bool
+		wantStep int64
+	}{
+		{"current step", rfcSecret, at(step), true, step},
+		{"previous step (skew)", rfcSecret, at(step - 1), true, step - 1},
+		{"next step (skew)", rfcSecret, at(step + 1), true, step + 1},
+		{"two steps back", rfcSecret, at(step - 2), false, 0},
+		{"two steps ahead", rfcSecret, at(step + 2), false, 0},
+		{"spaces in code", rfcSecret, " " + at(step)[:3] + " " + at(step)[3:] + " ", true, step},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", at(step), true, step},
+		{"too short", rfcSecret, at(step)[:5], false, 0},
+		{"too long", rfcSecret, at(step) + "0", false, 0},
+		{"wrong code", rfcSecret, "000000", false, 0},
+		{"bad secret", "not base32!", at(step), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.ok || got != tt.wantStep {
+				t.Errorf("ValidateTOTP = (%d, %v), want (%d, %v)", got, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for in, want := range map[string]string{
+		"k3md-9qxa-p2fz":   "k3md-9qxa-p2fz",
+		"K3MD9QXAP2FZ":     "k3md-9qxa-p2fz",
+		" k3md 9qxa p2fz ": "k3md-9qxa-p2fz",
+		"k3md-9qxa":        "k3md9qxa",
+	} {
+		if got := normalizeRecoveryCode(in); got != want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// twoFactorUser stores a user with TOTP on rfcSecret.
+func twoFactorUser(t *testing.T, h *Handler) *models.User {
+	t.Helper()
+	now := time.Now()
+	u := &models.User{Email: "totp@mail.com", Name: "TOTP", Role: models.RoleViewer, TOTPSecret: rfcSecret, TOTPEnabledAt: &now}
+	if err := h.users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSecondFactorRejectsReplay(t *testing.T) {
+	db := testdb.Open(t)
+	h := &Handler{users: users.NewRepository(db), tokens: NewTokenRepository(db)}
+	u := twoFactorUser(t, h)
+	now := time.Now()
+	step := now.Unix() / totpPeriod
+
+	code := hotp(rfcKey, uint64(step))
+	if _, ok, err := h.checkSecondFactor(u, code, ""); err != nil || !ok {
+		t.Fatalf("first use = (%v, %v), want accepted", ok, err)
+	}
+	if _, ok, err := h.checkSecondFactor(u, code, ""); err != nil || ok {
+		t.Fatalf("replay = (%v, %v), want rejected", ok, err)
+	}
+	// an older code still inside the skew window must not be accepted
+	// after a newer one
+	if _, ok, _ := h.checkSecondFactor(u, hotp(rfcKey, uint64(step-1)), ""); ok {
+		t.Fatal("code of an earlier step accepted after a later one")
+	}
+	stored, err := h.users.ByID(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TOTPLastStep != step {
+		t.Errorf("totp_last_step = %d, want %d", stored.TOTPLastStep, step)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	db := testdb.Open(t)
+	h := &Handler{users: users.NewRepository(db), tokens: NewTokenRepository(db)}
+	u := twoFactorUser(t, h)
+	if err := h.tokens.ReplaceRecoveryCodes(u.ID, []string{"k3md-9qxa-p2fz", "abcd-efgh-ijkl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := h.checkSecondFactor(u, "", "K3MD9QXAP2FZ"); err != nil || !ok {
+		t.Fatalf("first use = (%v, %v), want accepted", ok, err)
+	}
+	if _, ok, err := h.checkSecondFactor(u, "", "k3md-9qxa-p2fz"); err != nil || ok {
+		t.Fatalf("second use = (%v, %v), want rejected", ok, err)
+	}
+	if _, ok, _ := h.checkSecondFactor(u, "", "abcd-efgh-ijkl"); !ok {
+		t.Fatal("other code no longer accepted")
+	}
+
+	// replacing the codes invalidates the old ones
+	if err := h.tokens.ReplaceRecoveryCodes(u.ID, []string{"zzzz-zzzz-zzzz"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := h.checkSecondFactor(u, "", "abcd-efgh-ijkl"); ok {
+		t.Fatal("replaced code accepted")
+	}
+}
@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "Bookshelf"
+	recoveryCodeCount = 10
+)
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code and reports the AMR value to record for it.
+func (h *Handler) checkSecondFactor(u *models.User, code, recoveryCode string) (string, bool, error) {
+	if code != "" {
+		step, ok := ValidateTOTP(u.TOTPSecret, code, time.Now())
+		if !ok {
+			return "", false, nil
+		}
+		fresh, err := h.users.AdvanceTOTPStep(u.ID, step)
+		return "otp", fresh, err
+	}
+	if recoveryCode != "" {
+		ok, err := h.tokens.UseRecoveryCode(u.ID, normalizeRecoveryCode(recoveryCode))
+		return "otp", ok, err
+	}
+	return "", false, nil
+}
+
+type loginTOTPDTO struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"           example:"123456"`
+	RecoveryCode   string `json:"recoveryCode"   example:"k3md-9qxa-p2fz"`
+}
+
+// loginTOTP godoc
+// @Summary Finish a login with a TOTP or recovery code
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Param   payload body loginTOTPDTO true "Challenge from /auth/login plus code or recoveryCode"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Router  /auth/login/2fa [post]
+func (h *Handler) loginTOTP(c *gin.Context) {
+	var in loginTOTPDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	if in.Code == "" && in.RecoveryCode == "" {
+		api.Fail(c, http.StatusBadRequest, "code or recoveryCode is required")
+		return
+	}
+	claims, err := h.keys.ParseChallenge(in.ChallengeToken)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+	if revoked, err := h.tokens.IsRevoked(claims.ID, ""); err != nil || revoked {
+		api.Fail(c, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+
+	ip := c.ClientIP()
+	wait, err := h.throttle.Reserve(claims.Email, ip)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check login attempts"))
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	u, err := h.users.ByID(claims.UserID)
+	if err != nil || u.TOTPEnabledAt == nil {
+		api.Fail(c, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+	if u.DisabledAt != nil {
+		accountDisabled(c, http.StatusForbidden)
+		return
+	}
+	method, ok, err := h.checkSecondFactor(u, in.Code, in.RecoveryCode)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check code"))
+		return
+	}
+	if !ok {
+		if err := h.throttle.Failure(claims.Email, ip); err != nil {
+			log.Printf("⚠️  failed to record login failure: %v", err)
+		}
+		_ = h.users.RecordLoginFailure(u.ID)
+		api.Fail(c, http.StatusUnauthorized, "invalid code")
+		return
+	}
+
+	// a challenge completes exactly one login
+	_ = h.tokens.RevokeAccess(claims.ID, u.ID, claims.ExpiresAt.Time)
+	if err := h.throttle.Success(claims.Email, ip); err != nil {
+		log.Printf("⚠️  failed to reset login attempts: %v", err)
+	}
+	_ = h.users.RecordLogin(u.ID)
+	h.startSession(c, http.StatusOK, u, append(claims.AMR, method)...)
+}
+
+// setupTOTP godoc
+// @Summary Start TOTP enrollment
+// @Description Generates a new secret for the current user. 2FA is only switched on once a code from the authenticator is confirmed at /auth/2fa/verify.
+// @Tags    auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]any
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /auth/2fa/setup [post]
+func (h *Handler) setupTOTP(c *gin.Context) {
+	uid, _ := GetUserID(c)
+	u, err := h.users.ByID(uid)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.TOTPEnabledAt != nil {
+		api.Fail(c, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to generate secret"))
+		return
+	}
+	u.TOTPSecret = secret
+	u.TOTPLastStep = 0
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to save secret"))
+		return
+	}
+	api.OK(c, gin.H{"secret": secret, "otpauthUrl": TOTPURI(totpIssuer, u.Email, secret)})
+}
+
+type totpCodeDTO struct {
+	Code string `json:"code" binding:"required" example:"123456"`
+}
+
+// verifyTOTP godoc
+// @Summary Confirm TOTP enrollment
+// @Description Turns 2FA on and returns one-time recovery codes, which are shown only once. Log in again to get a session that counts as two-factor.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body totpCodeDTO true "Code from the authenticator app"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /auth/2fa/verify [post]
+func (h *Handler) verifyTOTP(c *gin.Context) {
+	var in totpCodeDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	uid, _ := GetUserID(c)
+	u, err := h.users.ByID(uid)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.TOTPEnabledAt != nil {
+		api.Fail(c, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	if u.TOTPSecret == "" {
+		api.Fail(c, http.StatusBadRequest, "call /auth/2fa/setup first")
+		return
+	}
+	step, ok := ValidateTOTP(u.TOTPSecret, in.Code, time.Now())
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, "invalid code")
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			api.Abort(c, api.Internal(err, "failed to generate recovery codes"))
+			return
+		}
+	}
+	if err := h.tokens.ReplaceRecoveryCodes(u.ID, codes); err != nil {
+		api.Abort(c, api.Internal(err, "failed to store recovery codes"))
+		return
+	}
+	now := time.Now()
+	u.TOTPEnabledAt = &now
+	u.TOTPLastStep = step
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to enable two-factor authentication"))
+		return
+	}
+	api.OK(c, gin.H{"enabled": true, "recoveryCodes": codes})
+}
+
+type disableTOTPDTO struct {
+	Password     string `json:"password"     binding:"required"`
+	Code         string `json:"code"         example:"123456"`
+	RecoveryCode string `json:"recoveryCode" example:"k3md-9qxa-p2fz"`
+}
+
+// disableTOTP godoc
+// @Summary Turn off TOTP
+// @Description Requires the current password and a TOTP or recovery code.
+// @Tags    auth
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body disableTOTPDTO true "Password plus code or recoveryCode"
+// @Success 200 {object} map[string]any
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Router  /auth/2fa/disable [post]
+func (h *Handler) disableTOTP(c *gin.Context) {
+	var in disableTOTPDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	uid, _ := GetUserID(c)
+	u, err := h.users.ByID(uid)
+	if err != nil {
+		api.Fail(c, http.StatusUnauthorized, "user no longer exists")
+		return
+	}
+	if u.TOTPEnabledAt == nil {
+		api.Fail(c, http.StatusBadRequest, "two-factor authentication is not enabled")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(in.Password)) != nil {
+		api.Fail(c, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	_, ok, err := h.checkSecondFactor(u, in.Code, in.RecoveryCode)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to check code"))
+		return
+	}
+	if !ok {
+		api.Fail(c, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	u.TOTPSecret = ""
+	u.TOTPEnabledAt = nil
+	u.TOTPLastStep = 0
+	if err := h.users.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to disable two-factor authentication"))
+		return
+	}
+	if err := h.tokens.ReplaceRecoveryCodes(u.ID, nil); err != nil {
+		log.Printf("⚠️  failed to delete recovery codes of user %d: %v", u.ID, err)
+	}
+	api.OK(c, gin.H{"enabled": false})
+}
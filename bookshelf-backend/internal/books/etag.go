@@ -0,0 +1,63 @@
+package books
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+// etag is the strong entity tag of b: its quoted version.
+func etag(b *models.Book) string {
+	return `"` + strconv.Itoa(b.Version) + `"`
+}
+
+// etagsMatch reports whether the If-Match or If-None-Match header value
+// lists tag or is "*". With weak set a W/ prefix is ignored, as
+// If-None-Match does; If-Match only takes strong tags.
+func etagsMatch(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of b and answers 304 when the client's
+// If-None-Match already has it.
+func notModified(c *gin.Context, b *models.Book) bool {
+	tag := etag(b)
+	c.Header("ETag", tag)
+	if inm := c.GetHeader("If-None-Match"); inm != "" && etagsMatch(inm, tag, true) {
+		c.Status(304)
+		return true
+	}
+	return false
+}
+
+// checkIfMatch lets a write to b go ahead only if the request's If-Match
+// names its current ETag. It answers 428 without the header and 412 when
+// the book has changed since the client read it.
+func checkIfMatch(c *gin.Context, b *models.Book) bool {
+	im := c.GetHeader("If-Match")
+	if im == "" {
+		api.Fail(c, 428, "If-Match header with the book's ETag is required")
+		return false
+	}
+	if !etagsMatch(im, etag(b), false) {
+		failStale(c)
+		return false
+	}
+	return true
+}
+
+func failStale(c *gin.Context) {
+	api.Fail(c, 412, "the book was changed by someone else; reload it and try again")
+}
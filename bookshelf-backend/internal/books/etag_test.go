@@ -0,0 +1,139 @@
+package books
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestEtagsMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"3"`, false, true},
+		{`"2"`, false, false},
+		{`"1", "3"`, false, true},
+		{`"1","3"`, false, true},
+		{`*`, false, true},
+		{`W/"3"`, false, false},
+		{`W/"3"`, true, true},
+		{`"1", W/"3"`, true, true},
+		{`3`, true, false},
+		{`"33"`, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagsMatch(tt.header, `"3"`, tt.weak); got != tt.want {
+			t.Errorf("etagsMatch(%q, weak=%v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
+
+// createBook creates a book from a JSON body and returns it with its ETag.
+func createBook(t *testing.T, r *gin.Engine, body string) (models.Book, string) {
+	t.Helper()
+	w := serveJSON(r, "POST", "/books", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	return decodeBook(t, w), w.Header().Get("ETag")
+}
+
+func decodeBook(t *testing.T, w *httptest.ResponseRecorder) models.Book {
+	t.Helper()
+	var env struct{ Data models.Book }
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return env.Data
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	_, r := newTestHandler(t)
+	b, tag := createBook(t, r, `{"title":"Dune","isbn13":"9780441013593"}`)
+	if tag == "" {
+		t.Fatal("create sent no ETag")
+	}
+	path := fmt.Sprintf("/books/%d", b.ID)
+
+	for _, tt := range []struct {
+		path, inm string
+		want      int
+	}{
+		{path, "", 200},
+		{path, tag, 304},
+		{path, "W/" + tag, 304},
+		{path, `"0", ` + tag, 304},
+		{path, "*", 304},
+		{path, `"0"`, 200},
+		{"/books/isbn/978-0-441-01359-3", tag, 304},
+		{"/books/isbn/0441013597", `"0"`, 200},
+	} {
+		w := serve(r, "GET", tt.path, nil, "If-None-Match", tt.inm)
+		if w.Code != tt.want {
+			t.Errorf("GET %s If-None-Match %s = %d, want %d", tt.path, tt.inm, w.Code, tt.want)
+		}
+		if got := w.Header().Get("ETag"); got != tag {
+			t.Errorf("GET %s If-None-Match %s: ETag %q, want %q", tt.path, tt.inm, got, tag)
+		}
+		if tt.want == 304 && w.Body.Len() != 0 {
+			t.Errorf("304 with a body: %s", w.Body)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	_, r := newTestHandler(t)
+	b, tag := createBook(t, r, `{"title":"Dune"}`)
+	path := fmt.Sprintf("/books/%d", b.ID)
+	patch := func(body string, header ...string) *httptest.ResponseRecorder {
+		return serve(r, "PATCH", path, strings.NewReader(body), append([]string{"Content-Type", "application/merge-patch+json"}, header...)...)
+	}
+
+	// without If-Match nothing is written
+	if w := patch(`{"title":"Emma"}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without If-Match = %d, want 428", w.Code)
+	}
+	if w := serveJSON(r, "PUT", path, `{"title":"Emma"}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("PUT without If-Match = %d, want 428", w.Code)
+	}
+	if w := serve(r, "DELETE", path, nil); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("DELETE without If-Match = %d, want 428", w.Code)
+	}
+	// If-Match compares strongly
+	if w := patch(`{"title":"Emma"}`, "If-Match", "W/"+tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a weak ETag = %d, want 412", w.Code)
+	}
+
+	w := patch(`{"title":"Emma"}`, "If-Match", tag)
+	if w.Code != 200 {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body)
+	}
+	newTag := w.Header().Get("ETag")
+	if newTag == "" || newTag == tag {
+		t.Fatalf("ETag after PATCH = %q, was %q", newTag, tag)
+	}
+
+	// the copy read before the PATCH is stale now
+	if w := serveJSON(r, "PUT", path, `{"title":"Ulysses"}`, "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag = %d, want 412", w.Code)
+	}
+	if w := serve(r, "DELETE", path, nil, "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale ETag = %d, want 412", w.Code)
+	}
+	w = serve(r, "GET", path, nil, "If-None-Match", tag)
+	if w.Code != 200 || decodeBook(t, w).Title != "Emma" {
+		t.Errorf("GET with the stale ETag = %d %s, want 200 with the PATCHed book", w.Code, w.Body)
+	}
+
+	if w := serve(r, "DELETE", path, nil, "If-Match", `"0", `+newTag); w.Code != 200 {
+		t.Errorf("DELETE with the current ETag = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,413 @@
+package books
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+const exportBatch = 500
+
+// exportColumn is one column of an export. value returns a string,
+// float64, int, []string, time.Time or nil.
+type exportColumn struct {
+	name  string
+	value func(b *models.Book, baseURL string) any
+}
+
+func termNames[T any](list []T, name func(T) string) []string {
+	out := make([]string, len(list))
+	for i, t := range list {
+		out[i] = name(t)
+	}
+	return out
+}
+
+var exportColumns = []exportColumn{
+	{"id", func(b *models.Book, _ string) any { return int(b.ID) }},
+	{"title", func(b *models.Book, _ string) any { return b.Title }},
+	{"isbn13", func(b *models.Book, _ string) any { return deref(b.ISBN13) }},
+	{"isbn10", func(b *models.Book, _ string) any { return deref(b.ISBN10) }},
+	{"authors", func(b *models.Book, _ string) any {
+		return termNames(b.Authors, func(t models.Author) string { return t.Name })
+	}},
+	{"categories", func(b *models.Book, _ string) any {
+		return termNames(b.Categories, func(t models.Category) string { return t.Name })
+	}},
+	{"publishers", func(b *models.Book, _ string) any {
+		return termNames(b.Publishers, func(t models.Publisher) string { return t.Name })
+	}},
+	{"price", func(b *models.Book, _ string) any { return b.Price }},
+	{"stock", func(b *models.Book, _ string) any { return b.Stock }},
+	{"pageCount", func(b *models.Book, _ string) any {
+		if b.PageCount == nil {
+			return nil
+		}
+		return *b.PageCount
+	}},
+	{"publishedDate", func(b *models.Book, _ string) any { return b.PublishedDate }},
+	{"description", func(b *models.Book, _ string) any { return b.Description }},
+	{"coverUrl", func(b *models.Book, base string) any {
+		if b.CoverURL == "" || strings.Contains(b.CoverURL, "://") {
+			return b.CoverURL
+		}
+		return base + b.CoverURL
+	}},
+	{"createdAt", func(b *models.Book, _ string) any { return timeOrNil(b.CreatedAt) }},
+	{"updatedAt", func(b *models.Book, _ string) any { return timeOrNil(b.UpdatedAt) }},
+}
+
+// timeOrNil leaves out timestamps missing on rows from before GORM set them.
+func timeOrNil(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// exportColumnsFor picks the columns named in the comma separated list,
+// in that order; empty means all.
+func exportColumnsFor(list string) ([]exportColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return exportColumns, nil
+	}
+	var out []exportColumn
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(exportColumns, func(c exportColumn) bool { return strings.EqualFold(c.name, name) })
+		if i < 0 {
+			names := make([]string, len(exportColumns))
+			for j, c := range exportColumns {
+				names[j] = c.name
+			}
+			return nil, fmt.Errorf("unknown column %q (want %s)", name, strings.Join(names, ", "))
+		}
+		out = append(out, exportColumns[i])
+	}
+	return out, nil
+}
+
+// baseURL is where clients reach the API: the configured public URL or,
+// without one, the scheme and host the request came in on. X-Forwarded-*
+// headers are not used, as any client can send them.
+func (h *Handler) baseURL(c *gin.Context) string {
+	if h.publicURL != "" {
+		return h.publicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// exportWriter writes one export format.
+type exportWriter interface {
+	header(cols []exportColumn) error
+	row(values []any) error
+	close() error
+}
+
+// exportBooks godoc
+// @Summary Export books
+// @Description Streams every book matching the filters of GET /books (q, category, author, publisher, minPrice, maxPrice, inStock, createdFrom, createdTo), in id order. columns picks and orders the columns: id, title, isbn13, isbn10, authors, categories, publishers, price, stock, pageCount, publishedDate, description, coverUrl (absolute), createdAt, updatedAt. Names in authors, categories and publishers are joined with "; " in CSV and XLSX and are arrays in JSON Lines.
+// @Tags    books
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Security BearerAuth
+// @Param   format  query string false "csv, jsonl or xlsx" default(csv)
+// @Param   columns query string false "Comma separated columns, all by default"
+// @Param   q       query string false "Full-text search"
+// @Success 200 {file} file
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Router  /books/export [get]
+func (h *Handler) Export(c *gin.Context) {
+	f, err := parseFilter(c)
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+	cols, err := exportColumnsFor(c.Query("columns"))
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+
+	var w exportWriter
+	format := c.DefaultQuery("format", "csv")
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w = &csvExport{w: csv.NewWriter(c.Writer)}
+	case "jsonl":
+		c.Header("Content-Type", "application/x-ndjson")
+		w = &jsonlExport{w: c.Writer}
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w = &xlsxExport{zw: zip.NewWriter(c.Writer)}
+	default:
+		api.Fail(c, 400, "format must be csv, jsonl or xlsx")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="books-%s.%s"`, time.Now().Format("20060102"), format))
+	c.Status(http.StatusOK)
+
+	// from here on the response has started, so errors can only be logged
+	base := h.baseURL(c)
+	values := make([]any, len(cols))
+	err = w.header(cols)
+	if err == nil {
+		err = h.repo.Export(f, exportBatch, func(books []models.Book) error {
+			for i := range books {
+				for j, col := range cols {
+					values[j] = col.value(&books[i], base)
+				}
+				if err := w.row(values); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	}
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		log.Printf("⚠️  book export failed: %v", err)
+	}
+}
+
+// cell formats a value for CSV.
+func cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, "; ")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ---------- CSV ----------
+
+type csvExport struct{ w *csv.Writer }
+
+func (e *csvExport) header(cols []exportColumn) error {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.name
+	}
+	return e.w.Write(names)
+}
+
+func (e *csvExport) row(values []any) error {
+	rec := make([]string, len(values))
+	for i, v := range values {
+		rec[i] = cell(v)
+		switch v.(type) {
+		case string, []string:
+			rec[i] = csvText(rec[i])
+		}
+	}
+	return e.w.Write(rec)
+}
+
+// csvText keeps spreadsheets from running a text cell as a formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (e *csvExport) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ---------- JSON Lines ----------
+
+type jsonlExport struct {
+	w    io.Writer
+	keys [][]byte // JSON encoded column names
+}
+
+func (e *jsonlExport) header(cols []exportColumn) error {
+	for _, c := range cols {
+		k, _ := json.Marshal(c.name)
+		e.keys = append(e.keys, k)
+	}
+	return nil
+}
+
+// row writes the object by hand to keep the keys in column order.
+func (e *jsonlExport) row(values []any) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	line := []byte{'{'}
+	for i, v := range values {
+		b.Reset()
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, e.keys[i]...)
+		line = append(line, ':')
+		line = append(line, bytes.TrimRight(b.Bytes(), "\n")...)
+	}
+	line = append(line, '}', '\n')
+	_, err := e.w.Write(line)
+	return err
+}
+
+func (e *jsonlExport) close() error { return nil }
+
+// ---------- XLSX ----------
+
+// xlsxExport writes a single-sheet workbook. The sheet is the last part
+// of the zip and is streamed row by row, with strings inline so no shared
+// string table has to be built up front.
+type xlsxExport struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Books" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// style 1 is a date-time number format, used for timestamps
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font/></fonts><fills count="1"><fill/></fills><borders count="1"><border/></borders>` +
+		`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf/><xf numFmtId="22" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func (e *xlsxExport) header(cols []exportColumn) error {
+	for _, p := range xlsxParts {
+		w, err := e.zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, p.body); err != nil {
+			return err
+		}
+	}
+	w, err := e.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	e.sheet = w
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+	names := make([]any, len(cols))
+	for i, c := range cols {
+		names[i] = c.name
+	}
+	return e.row(names)
+}
+
+// xlsxColumn returns the column letters for index i (0 -> A, 26 -> AA).
+func xlsxColumn(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// excelEpoch is day 0 of Excel's date serial numbers.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func (e *xlsxExport) row(values []any) error {
+	e.rows++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, e.rows)
+	for i, v := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(e.rows)
+		switch v := v.(type) {
+		case nil:
+			continue
+		case float64, int:
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, cell(v))
+		case time.Time:
+			days := v.UTC().Sub(excelEpoch).Hours() / 24
+			fmt.Fprintf(&b, `<c r="%s" s="1"><v>%s</v></c>`, ref, strconv.FormatFloat(days, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			if err := xml.EscapeText(&b, []byte(cell(v))); err != nil {
+				return err
+			}
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(e.sheet, b.String())
+	return err
+}
+
+func (e *xlsxExport) close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
@@ -0,0 +1,83 @@
+package books
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/csv"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	e := &csvExport{w: csv.NewWriter(&buf)}
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	err := e.row([]any{
+		"=HYPERLINK(\"http://evil\")",
+		[]string{"@SUM(A1:A2)", "Frank Herbert"},
+		[]string{"Frank Herbert", "=1+1"},
+		"-2 dashes",
+		"Dune",
+		-1.5,
+		created,
+		nil,
+	})
+	if err == nil {
+		err = e.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"'=HYPERLINK(\"http://evil\")",
+		"'@SUM(A1:A2); Frank Herbert",
+		"Frank Herbert; =1+1", // not at the start, so not a formula
+		"'-2 dashes",
+		"Dune",
+		"-1.5", // numbers stay numbers
+		"2024-05-01T00:00:00Z",
+		"",
+	}
+	for i := range want {
+		if rec[i] != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, rec[i], want[i])
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		publicURL string
+		tls       bool
+		want      string
+	}{
+		{"request host", "", false, "http://books.local:8080"},
+		{"tls", "", true, "https://books.local:8080"},
+		{"configured", "https://api.example.com/", false, "https://api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, Terms{}, nil, nil, "", tt.publicURL)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "http://books.local:8080/books/export", nil)
+			if tt.tls {
+				c.Request.TLS = &tls.ConnectionState{}
+			}
+			// forwarded headers come from whoever sends the request
+			c.Request.Header.Set("X-Forwarded-Host", "evil.example")
+			c.Request.Header.Set("X-Forwarded-Proto", "https")
+			if got := h.baseURL(c); got != tt.want {
+				t.Errorf("baseURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package books
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/catalog"
+	"gorm.io/gorm"
+)
+
+// facetLimit caps the values returned per category/author/publisher facet.
+const facetLimit = 20
+
+// priceEdges are the lower bounds of the price buckets after the first,
+// which starts at 0.
+var priceEdges = []float64{50000, 100000, 200000, 500000}
+
+// Facets counts the books matching f per category, author, publisher and
+// price bucket.
+func (r *Repository) Facets(f Filter) (api.BookFacets, error) {
+	var out api.BookFacets
+	for _, t := range []struct {
+		kind catalog.Kind
+		dst  *[]api.FacetCount
+	}{{catalog.Categories, &out.Categories}, {catalog.Authors, &out.Authors}, {catalog.Publishers, &out.Publishers}} {
+		counts, err := r.termFacet(t.kind, r.filtered(f, t.kind.Table))
+		if err != nil {
+			return out, err
+		}
+		*t.dst = counts
+	}
+	price, err := r.priceFacet(r.filtered(f, facetPrice))
+	if err != nil {
+		return out, err
+	}
+	out.Price = price
+	return out, nil
+}
+
+func (r *Repository) termFacet(kind catalog.Kind, books *gorm.DB) ([]api.FacetCount, error) {
+	out := []api.FacetCount{}
+	err := r.db.Table(kind.JoinTable+" j").
+		Select("t.id, t.name, count(*) AS count").
+		Joins("JOIN "+kind.Table+" t ON t.id = j."+kind.JoinKey).
+		Where("j.book_id IN (?)", books.Select("books.id")).
+		Group("t.id, t.name").
+		Order("count DESC, lower(t.name)").
+		Limit(facetLimit).
+		Scan(&out).Error
+	return out, err
+}
+
+func (r *Repository) priceFacet(books *gorm.DB) (api.PriceFacet, error) {
+	var out api.PriceFacet
+	var rows []struct {
+		Bucket int
+		Count  int64
+		Min    float64
+		Max    float64
+	}
+	// width_bucket gives 0 below the first edge and len(edges) from the last.
+	// gorm would expand a slice argument into a list, so the edges are
+	// written into the query.
+	edges := make([]string, len(priceEdges))
+	for i, e := range priceEdges {
+		edges[i] = strconv.FormatFloat(e, 'f', -1, 64)
+	}
+	err := r.db.Table("books").
+		Select("width_bucket(price, ARRAY["+strings.Join(edges, ",")+"]::numeric[]) AS bucket, "+
+			"count(*) AS count, min(price) AS min, max(price) AS max").
+		Where("id IN (?)", books.Select("books.id")).
+		Group("bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return out, err
+	}
+
+	out.Buckets = make([]api.PriceBucket, len(priceEdges)+1)
+	for i := range out.Buckets {
+		if i > 0 {
+			out.Buckets[i].From = priceEdges[i-1]
+		}
+		if i < len(priceEdges) {
+			out.Buckets[i].To = &priceEdges[i]
+		}
+	}
+	for _, row := range rows {
+		if row.Bucket < 0 || row.Bucket >= len(out.Buckets) {
+			continue
+		}
+		out.Buckets[row.Bucket].Count = row.Count
+		if out.Min == nil || row.Min < *out.Min {
+			out.Min = &row.Min
+		}
+		if out.Max == nil || row.Max > *out.Max {
+			out.Max = &row.Max
+		}
+	}
+	return out, nil
+}
@@ -1,25 +1,37 @@
 package books
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"github.com/giovannyptr/bookshelf/internal/cursor"
+	"github.com/giovannyptr/bookshelf/internal/isbn"
+	"github.com/giovannyptr/bookshelf/internal/metadata"
 	"github.com/giovannyptr/bookshelf/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
 	repo      *Repository
+	terms     Terms
+	meta      metadata.Provider // nil when lookups are disabled
+	cursors   *cursor.Signer
 	uploadDir string
+	publicURL string       // e.g. https://api.example.com; "" uses the request's host
+	covers    *http.Client // see newCoverClient
 }
 
-func NewHandler(repo *Repository, uploadDir string) *Handler {
-	return &Handler{repo: repo, uploadDir: uploadDir}
+func NewHandler(repo *Repository, terms Terms, meta metadata.Provider, cursors *cursor.Signer, uploadDir, publicURL string) *Handler {
+	return &Handler{repo: repo, terms: terms, meta: meta, cursors: cursors, uploadDir: uploadDir, publicURL: strings.TrimRight(publicURL, "/"), covers: newCoverClient()}
 }
 
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
@@ -28,244 +40,511 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 
 	g := r.Group("/books")
 	g.GET("", h.List)
+	g.GET("/isbn/:isbn", h.ByISBN)
+	g.POST("/isbn/:isbn", h.CreateFromISBN)
+	g.GET("/lookup/:isbn", h.LookupISBN)
+	g.GET("/export", h.Export)
+	g.POST("/import", h.Import)
+	g.GET("/import/:id", h.ImportReport)
+	g.GET("/import/:id/errors.csv", h.ImportErrors)
+	g.GET("/trash", h.Trash)
+	g.POST("/:id/restore", h.Restore)
+	g.GET("/:id/history", h.History)
+	g.POST("/:id/revert/:revision", h.Revert)
 	g.GET("/:id", h.Detail)
 	g.POST("", h.Create)
 	g.PUT("/:id", h.Update)
+	g.PATCH("/:id", h.Patch)
 	g.DELETE("/:id", h.Delete)
 }
 
+// queryValues returns the non-empty values of a repeatable query param.
+func queryValues(c *gin.Context, key string) []string {
+	var out []string
+	for _, v := range c.QueryArray(key) {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// queryDate parses a date (2006-01-02) or timestamp (RFC 3339) param. A
+// plain date given as an end bound covers that whole day.
+func queryDate(c *gin.Context, key string, end bool) (*time.Time, error) {
+	s := strings.TrimSpace(c.Query(key))
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", key)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func queryPrice(c *gin.Context, key string) (*float64, error) {
+	s := strings.TrimSpace(c.Query(key))
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	return &v, nil
+}
+
+// pageParams reads page (from 1) and limit (1-100, default 10).
+func pageParams(c *gin.Context) (page, limit int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	return
+}
+
+// parseFilter reads the GET /books filters from the query string.
+func parseFilter(c *gin.Context) (Filter, error) {
+	f := Filter{
+		Query:      strings.TrimSpace(c.Query("q")),
+		Categories: queryValues(c, "category"),
+		Authors:    queryValues(c, "author"),
+		Publishers: queryValues(c, "publisher"),
+		InStock:    c.Query("inStock") == "true",
+	}
+	var err error
+	if f.MinPrice, err = queryPrice(c, "minPrice"); err != nil {
+		return f, err
+	}
+	if f.MaxPrice, err = queryPrice(c, "maxPrice"); err != nil {
+		return f, err
+	}
+	if f.CreatedFrom, err = queryDate(c, "createdFrom", false); err != nil {
+		return f, err
+	}
+	if f.CreatedTo, err = queryDate(c, "createdTo", true); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
 // list godoc
 // @Summary List books
 // @Tags    books
 // @Produce json
-// @Param   q        query string false "Search by title/author"
-// @Param   category query string false "Filter by category"
-// @Param   page     query int    false "Page number"  default(1)
+// @Description q is a full-text search over title, author names and description (web search syntax: "quoted phrase", or, -excluded). Results then carry highlight snippets with matches wrapped in <b></b> (the text itself is not HTML-escaped).
+// @Description category, author and publisher can be repeated and match any of the values given; all filters present must match. facets counts the matching books per category, author, publisher and price bucket, each ignoring its own filter.
+// @Param   q           query string   false "Full-text search"
+// @Param   category    query []string false "Category ids or names" collectionFormat(multi)
+// @Param   author      query []string false "Author ids or names" collectionFormat(multi)
+// @Param   publisher   query []string false "Publisher ids or names" collectionFormat(multi)
+// @Param   minPrice    query number   false "Lowest price"
+// @Param   maxPrice    query number   false "Highest price"
+// @Param   inStock     query bool     false "Only books with stock"
+// @Param   createdFrom query string   false "Added on or after (YYYY-MM-DD or RFC 3339)"
+// @Param   createdTo   query string   false "Added on or before (YYYY-MM-DD or RFC 3339)"
+// @Description Pages can be walked with page, or with the next and prev cursors of the response passed back as cursor (stable while books are added; a cursor keeps the sort and order it was made with). count=false leaves out total.
+// @Param   cursor   query string false "next or prev cursor of a previous response"
+// @Param   page     query int    false "Page number, without cursor"  default(1)
 // @Param   limit    query int    false "Page size (1-100)" default(10)
-// @Param   sort     query string false "Sort field" default(created_at)
+// @Param   count    query bool   false "Compute total" default(true)
+// @Param   sort     query string false "title, price, created_at or relevance (default relevance with q, created_at without)"
 // @Param   order    query string false "ASC or DESC" default(DESC)
 // @Success 200 {object} api.PagedBooks
+// @Failure 400 {object} api.ErrorResponse
 // @Router  /books [get]
 func (h *Handler) List(c *gin.Context) {
-	q := strings.TrimSpace(c.Query("q"))
-	category := c.Query("category")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	if page < 1 {
-		page = 1
+	f, err := parseFilter(c)
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
 	}
-	if limit < 1 || limit > 100 {
-		limit = 10
+	page, limit := pageParams(c)
+	p := Page{
+		Sort:   c.Query("sort"),
+		Order:  strings.ToUpper(c.DefaultQuery("order", "DESC")),
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+		Count:  c.Query("count") != "false",
+	}
+	if p.Sort == "" {
+		p.Sort = "created_at"
+		if f.Query != "" {
+			p.Sort = "relevance"
+		}
+	}
+	if tok := c.Query("cursor"); tok != "" {
+		p.Cursor = &Cursor{}
+		if err := h.cursors.Decode(tok, p.Cursor); err != nil {
+			api.Fail(c, 400, err.Error())
+			return
+		}
 	}
-	sort := c.DefaultQuery("sort", "created_at")
-	order := strings.ToUpper(c.DefaultQuery("order", "DESC"))
 
-	items, total, err := h.repo.List(q, category, page, limit, sort, order)
+	res, err := h.repo.List(f, p)
+	if errors.Is(err, ErrBadCursor) {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	next, err := h.encodeCursor(res.Next)
 	if err != nil {
-		api.Fail(c, 500, err.Error())
+		api.Abort(c, err)
 		return
 	}
-	api.OK(c, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+	prev, err := h.encodeCursor(res.Prev)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	facets, err := h.repo.Facets(f)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	out := gin.H{"items": res.Items, "limit": limit, "next": next, "prev": prev, "facets": facets}
+	if res.Total != nil {
+		out["total"] = *res.Total
+	}
+	if p.Cursor == nil {
+		out["page"] = page
+	}
+	api.OK(c, out)
+}
+
+// encodeCursor signs cur; nil stays nil.
+func (h *Handler) encodeCursor(cur *Cursor) (*string, error) {
+	if cur == nil {
+		return nil, nil
+	}
+	tok, err := h.cursors.Encode(cur)
+	if err != nil {
+		return nil, err
+	}
+	return &tok, nil
 }
 
 // detail godoc
 // @Summary Get a book
+// @Description The ETag header carries the book's version; send it back as If-None-Match for a 304 while the book is unchanged, and as If-Match to update or delete it.
 // @Tags    books
 // @Produce json
 // @Param   id path string true "Book ID"
+// @Param   If-None-Match header string false "ETag of the copy the client has"
 // @Success 200 {object} models.Book
+// @Success 304 "Not modified"
 // @Failure 404 {object} api.ErrorResponse
 // @Router  /books/{id} [get]
 func (h *Handler) Detail(c *gin.Context) {
-	id := c.Param("id")
-	b, err := h.repo.ByID(id)
+	b, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if notModified(c, &b) {
+		return
+	}
+	api.OK(c, b)
+}
+
+// byISBN godoc
+// @Summary Get a book by ISBN
+// @Description Accepts ISBN-10 or ISBN-13, with or without hyphens.
+// @Tags    books
+// @Produce json
+// @Param   isbn path string true "ISBN-10 or ISBN-13"
+// @Param   If-None-Match header string false "ETag of the copy the client has"
+// @Success 200 {object} models.Book
+// @Success 304 "Not modified"
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /books/isbn/{isbn} [get]
+func (h *Handler) ByISBN(c *gin.Context) {
+	s, err := isbn.Normalize(c.Param("isbn"))
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+	b, err := h.repo.ByISBN(s)
 	if err != nil {
-		api.Fail(c, 404, "book not found")
+		api.Abort(c, api.NotFound(err, "book not found"))
+		return
+	}
+	if notModified(c, &b) {
 		return
 	}
 	api.OK(c, b)
 }
 
 type createForm struct {
-	Title    string  `form:"title"    example:"1984"`
-	Author   string  `form:"author"   example:"George Orwell"`
-	Category string  `form:"category" example:"Fiction"`
-	Price    float64 `form:"price"    example:"60000"`
-	Stock    int     `form:"stock"    example:"10"`
+	Title         string   `form:"title"      example:"1984"`
+	ISBN          string   `form:"isbn"       example:"978-0-451-52493-5"`
+	Authors       []string `form:"authors"    example:"George Orwell"`
+	Categories    []string `form:"categories" example:"Fiction"`
+	Publishers    []string `form:"publishers" example:"Secker & Warburg"`
+	Description   string   `form:"description"   example:"A dystopian novel."`
+	PageCount     int      `form:"pageCount"     example:"328"`
+	PublishedDate string   `form:"publishedDate" example:"1949"`
+	Price         float64  `form:"price"      example:"60000"`
+	Stock         int      `form:"stock"      example:"10"`
+}
+
+// uploadExt returns the extension of an uploaded cover, if it is an
+// accepted image type.
+func uploadExt(filename string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		ext = ".jpg"
+	}
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return ext, true
+	}
+	return "", false
+}
+
+// saveCover stores the uploaded cover, if the form has one, and returns
+// its URL. The extension was checked by formInput.
+func (h *Handler) saveCover(c *gin.Context) (string, error) {
+	file, err := c.FormFile("cover")
+	if err != nil || file == nil {
+		return "", nil
+	}
+	ext, _ := uploadExt(file.Filename)
+	filename := uuid.New().String() + ext
+	if err := c.SaveUploadedFile(file, filepath.Join(h.uploadDir, filename)); err != nil {
+		return "", err
+	}
+	return "/uploads/" + filename, nil
 }
 
 // create godoc
 // @Summary Create a book
 // @Tags    books
 // @Accept  multipart/form-data
+// @Accept  json
 // @Produce json
 // @Security BearerAuth
-// @Param   title     formData string  true  "Title"
-// @Param   author    formData string  false "Author"
-// @Param   category  formData string  false "Category"
-// @Param   price     formData number  false "Price"
-// @Param   stock     formData integer false "Stock"
-// @Param   cover     formData file    false "Cover image (.jpg/.jpeg/.png/.webp)"
+// @Description Authors, categories and publishers are given by id (authorIds, categoryIds, publisherIds; repeated or comma separated) or by name (authors, categories, publishers; repeated). Names not seen before are created. The single author and category fields are still accepted.
+// @Description The book can also be sent as a JSON body (see books.BookBody), without a cover. A 400 lists every invalid field in fields.
+// @Param   title        formData string   true  "Title"
+// @Param   isbn         formData string   false "ISBN-10 or ISBN-13, stored as ISBN-13"
+// @Param   authorIds    formData string   false "Author ids"
+// @Param   authors      formData []string false "Author names" collectionFormat(multi)
+// @Param   categoryIds  formData string   false "Category ids"
+// @Param   categories   formData []string false "Category names" collectionFormat(multi)
+// @Param   publisherIds formData string   false "Publisher ids"
+// @Param   publishers   formData []string false "Publisher names" collectionFormat(multi)
+// @Param   description   formData string   false "Description, searched by q"
+// @Param   pageCount     formData integer  false "Number of pages"
+// @Param   publishedDate formData string   false "Publication date as printed, e.g. 1949 or June 1949"
+// @Param   price        formData number   false "Price"
+// @Param   stock        formData integer  false "Stock"
+// @Param   cover        formData file     false "Cover image (.jpg/.jpeg/.png/.webp)"
 // @Success 201 {object} models.Book
-// @Failure 400 {object} api.ErrorResponse
+// @Failure 400 {object} api.FieldErrorResponse
 // @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
 // @Router  /books [post]
 func (h *Handler) Create(c *gin.Context) {
-	title := c.PostForm("title")
-	if title == "" {
-		api.Fail(c, 400, "title is required")
+	in, ok := readInput(c)
+	if !ok {
 		return
 	}
-	author := c.PostForm("author")
-	category := c.PostForm("category")
-	var price float64
-	var stock int
-	if s := c.PostForm("price"); s != "" {
-		v, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			api.Fail(c, 400, "price must be a number")
-			return
-		}
-		price = v
+	var b models.Book
+	if !h.bind(c, &b, in) {
+		return
 	}
-	if s := c.PostForm("stock"); s != "" {
-		v, err := strconv.Atoi(s)
-		if err != nil {
-			api.Fail(c, 400, "stock must be an integer")
-			return
-		}
-		stock = v
+	cover, err := h.saveCover(c)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to save cover"))
+		return
 	}
+	b.CoverURL = cover
 
-	coverURL := ""
-	if file, err := c.FormFile("cover"); err == nil && file != nil {
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		if ext == "" {
-			ext = ".jpg"
-		}
-		switch ext {
-		case ".jpg", ".jpeg", ".png", ".webp":
-		default:
-			api.Fail(c, 400, "cover must be .jpg/.jpeg/.png/.webp")
-			return
-		}
-		filename := uuid.New().String() + ext
-		dst := filepath.Join(h.uploadDir, filename)
-		if err := c.SaveUploadedFile(file, dst); err != nil {
-			api.Fail(c, 500, "failed to save cover")
-			return
-		}
-		coverURL = "/uploads/" + filename
+	uid, _ := auth.GetUserID(c)
+	err = h.create(&b, in, uid)
+	if err != nil {
+		h.removeCover(cover)
 	}
-
-	b := models.Book{Title: title, Author: author, Category: category, Price: price, Stock: stock, CoverURL: coverURL}
-	if err := h.repo.Create(&b); err != nil {
-		api.Fail(c, 500, err.Error())
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		api.Fail(c, 409, "a book with this ISBN already exists")
 		return
 	}
-	c.JSON(201, b)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	c.Header("ETag", etag(&b))
+	api.Created(c, b)
 }
 
 // update godoc
 // @Summary Update a book
 // @Tags    books
 // @Accept  multipart/form-data
+// @Accept  json
 // @Produce json
 // @Security BearerAuth
-// @Param   id        path     string  true  "Book ID"
-// @Param   title     formData string  false "Title"
-// @Param   author    formData string  false "Author"
-// @Param   category  formData string  false "Category"
+// @Description With form fields only the fields sent change, and a field sent empty is cleared; authors, categories and publishers are set as in create, an association left out keeps its links.
+// @Description A JSON body (see books.BookBody) replaces the book: members left out are cleared. Use PATCH to change some fields only. A 400 lists every invalid field in fields.
+// @Param   id           path     string   true  "Book ID"
+// @Param   If-Match     header   string   true  "ETag from GET /books/{id}"
+// @Param   title        formData string   false "Title"
+// @Param   isbn         formData string   false "ISBN-10 or ISBN-13"
+// @Param   authorIds    formData string   false "Author ids"
+// @Param   authors      formData []string false "Author names" collectionFormat(multi)
+// @Param   categoryIds  formData string   false "Category ids"
+// @Param   categories   formData []string false "Category names" collectionFormat(multi)
+// @Param   publisherIds formData string   false "Publisher ids"
+// @Param   publishers   formData []string false "Publisher names" collectionFormat(multi)
+// @Param   description   formData string  false "Description"
+// @Param   pageCount     formData integer false "Number of pages"
+// @Param   publishedDate formData string  false "Publication date"
 // @Param   price     formData number  false "Price"
 // @Param   stock     formData integer false "Stock"
 // @Param   cover     formData file    false "New cover"
 // @Success 200 {object} models.Book
-// @Failure 400 {object} api.ErrorResponse
+// @Failure 400 {object} api.FieldErrorResponse
 // @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
 // @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse "the book changed since the client read it"
+// @Failure 428 {object} api.ErrorResponse "If-Match missing"
 // @Router  /books/{id} [put]
 func (h *Handler) Update(c *gin.Context) {
-	id := c.Param("id")
-	b, err := h.repo.ByID(id)
-	if err != nil {
-		api.Fail(c, 404, "book not found")
+	h.update(c, false)
+}
+
+// patch godoc
+// @Summary Change some fields of a book
+// @Description The body is a JSON merge patch (RFC 7396): members present are set, null clears them, the rest is kept. Authors, categories and publishers are replaced as a whole. A 400 lists every invalid field in fields.
+// @Tags    books
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   id       path   string         true "Book ID"
+// @Param   If-Match header string         true "ETag from GET /books/{id}"
+// @Param   patch    body   books.BookBody true "Fields to change"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse "the book changed since the client read it"
+// @Failure 415 {object} api.ErrorResponse
+// @Failure 428 {object} api.ErrorResponse "If-Match missing"
+// @Router  /books/{id} [patch]
+func (h *Handler) Patch(c *gin.Context) {
+	if !isJSON(c) {
+		api.Fail(c, 415, "PATCH takes an application/merge-patch+json body")
 		return
 	}
+	h.update(c, true)
+}
 
-	if v := c.PostForm("title"); v != "" {
-		b.Title = v
-	}
-	if v := c.PostForm("author"); v != "" {
-		b.Author = v
-	}
-	if v := c.PostForm("category"); v != "" {
-		b.Category = v
-	}
-	if s := c.PostForm("price"); s != "" {
-		if v, err := strconv.ParseFloat(s, 64); err == nil {
-			b.Price = v
-		}
+// update is PUT, or PATCH with patch set.
+func (h *Handler) update(c *gin.Context, patch bool) {
+	b, ok := h.load(c)
+	if !ok {
+		return
 	}
-	if s := c.PostForm("stock"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil {
-			b.Stock = v
-		}
+	if !checkIfMatch(c, &b) {
+		return
 	}
 
-	if file, err := c.FormFile("cover"); err == nil && file != nil {
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		if ext == "" {
-			ext = ".jpg"
-		}
-		switch ext {
-		case ".jpg", ".jpeg", ".png", ".webp":
-		default:
-			api.Fail(c, 400, "cover must be .jpg/.jpeg/.png/.webp")
+	var in *bookInput
+	var err error
+	if patch {
+		if in, err = jsonInput(c.Request.Body, false); err != nil {
+			api.Fail(c, 400, err.Error())
 			return
 		}
-		filename := uuid.New().String() + ext
-		dst := filepath.Join(h.uploadDir, filename)
-		if b.CoverURL != "" {
-			_ = os.Remove("." + b.CoverURL)
-		}
-		if err := c.SaveUploadedFile(file, dst); err != nil {
-			api.Fail(c, 500, "failed to save new cover")
-			return
-		}
-		b.CoverURL = "/uploads/" + filename
+	} else if in, ok = readInput(c); !ok {
+		return
+	}
+	if !h.bind(c, &b, in) {
+		return
+	}
+	cover, err := h.saveCover(c)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to save new cover"))
+		return
+	}
+	if cover != "" {
+		// the old cover stays for the book's history until it is swept
+		b.CoverURL = cover
 	}
 
-	if err := h.repo.Save(&b); err != nil {
-		api.Fail(c, 500, err.Error())
+	uid, _ := auth.GetUserID(c)
+	err = h.save(&b, in, uid)
+	if err != nil {
+		h.removeCover(cover)
+	}
+	if errors.Is(err, ErrStale) {
+		failStale(c)
+		return
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		api.Fail(c, 409, "a book with this ISBN already exists")
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
 		return
 	}
+	c.Header("ETag", etag(&b))
 	api.OK(c, b)
 }
 
 // delete godoc
 // @Summary Delete a book
+// @Description Moves the book to the trash, from where it can be restored until it is purged.
 // @Tags    books
 // @Produce json
 // @Security BearerAuth
-// @Param   id path string true "Book ID"
+// @Param   id       path   string true "Book ID"
+// @Param   If-Match header string true "ETag from GET /books/{id}"
 // @Success 200 {object} map[string]string
 // @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
 // @Failure 404 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse "the book changed since the client read it"
+// @Failure 428 {object} api.ErrorResponse "If-Match missing"
 // @Router  /books/{id} [delete]
 func (h *Handler) Delete(c *gin.Context) {
-	id := c.Param("id")
-	b, err := h.repo.ByID(id)
-	if err != nil {
-		api.Fail(c, 404, "book not found")
+	b, ok := h.load(c)
+	if !ok {
 		return
 	}
-
-	if b.CoverURL != "" {
-		_ = os.Remove("." + b.CoverURL)
+	if !checkIfMatch(c, &b) {
+		return
+	}
+	uid, _ := auth.GetUserID(c)
+	err := h.repo.Delete(&b, uid)
+	if errors.Is(err, ErrStale) {
+		failStale(c)
+		return
 	}
-	if err := h.repo.Delete(&b); err != nil {
-		api.Fail(c, 500, err.Error())
+	if err != nil {
+		api.Abort(c, err)
 		return
 	}
-	api.OK(c, gin.H{"message": fmt.Sprintf("book %s deleted", id)})
+	api.OK(c, gin.H{"message": fmt.Sprintf("book %d moved to the trash", b.ID)})
 }
@@ -0,0 +1,129 @@
+package books
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/catalog"
+	"github.com/giovannyptr/bookshelf/internal/cursor"
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+// newTestHandler serves the book routes, without auth, from a fresh
+// database and upload directory.
+func newTestHandler(t *testing.T) (*Handler, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	h := NewHandler(NewRepository(db), Terms{
+		Authors:    catalog.NewRepository(db, catalog.Authors),
+		Categories: catalog.NewRepository(db, catalog.Categories),
+		Publishers: catalog.NewRepository(db, catalog.Publishers),
+	}, nil, cursor.NewSigner([]byte("test")), t.TempDir(), "")
+	r := gin.New()
+	h.RegisterRoutes(r)
+	return h, r
+}
+
+// serve sends a request with the given body and header name/value pairs.
+func serve(r http.Handler, method, path string, body io.Reader, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// serveJSON sends body as application/json.
+func serveJSON(r http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	return serve(r, method, path, strings.NewReader(body), append([]string{"Content-Type", "application/json"}, header...)...)
+}
+
+// serveForm sends fields and a cover image as multipart/form-data.
+func serveForm(t *testing.T, r http.Handler, method, path string, fields map[string]string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("cover", "cover.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("not really a jpeg"))
+	mw.Close()
+	return serve(r, method, path, &body, append([]string{"Content-Type", mw.FormDataContentType()}, header...)...)
+}
+
+// uploads lists the files in the upload directory.
+func uploads(t *testing.T, h *Handler) []string {
+	t.Helper()
+	entries, err := os.ReadDir(h.uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestCoverRemovedWhenSaveFails(t *testing.T) {
+	h, r := newTestHandler(t)
+	w := serveJSON(r, "POST", "/books", `{"title":"Dune","isbn13":"9780441013593"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+
+	w = serveForm(t, r, "POST", "/books", map[string]string{"title": "Dune again", "isbn": "9780441013593"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("duplicate create = %d %s, want 409", w.Code, w.Body)
+	}
+	if files := uploads(t, h); len(files) != 0 {
+		t.Errorf("cover of the failed create kept: %v", files)
+	}
+
+	w = serveForm(t, r, "POST", "/books", map[string]string{"title": ""})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid create = %d %s, want 400", w.Code, w.Body)
+	}
+	if files := uploads(t, h); len(files) != 0 {
+		t.Errorf("cover of the invalid create stored: %v", files)
+	}
+}
+
+func TestFailedSaveCreatesNoTerms(t *testing.T) {
+	h, r := newTestHandler(t)
+	if w := serveJSON(r, "POST", "/books", `{"title":"Dune","isbn13":"9780441013593"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+
+	// a book that gets past bind but fails to store, as when another
+	// request takes the ISBN in between
+	s := "9780441013593"
+	b := models.Book{Title: "Dune again", ISBN13: &s}
+	in := newInput("isbn13")
+	in.terms["Authors"] = termInput{names: []string{"Frank Herbert"}}
+	if err := h.create(&b, in, 0); err == nil {
+		t.Fatal("create with a taken ISBN succeeded")
+	}
+	if _, err := h.terms.Authors.ByName("Frank Herbert"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("author of the failed create: err = %v, want not found", err)
+	}
+}
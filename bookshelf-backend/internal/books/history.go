@@ -0,0 +1,261 @@
+package books
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"github.com/giovannyptr/bookshelf/internal/catalog"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+// record stores a revision of book id by userID (0 if unknown), numbered
+// after the book's last one. The caller holds the book's row lock.
+func record(tx *gorm.DB, id uint, action string, userID uint, before, after *models.Book) error {
+	rev := models.BookRevision{BookID: id, Action: action, Before: snapshot(before), After: snapshot(after)}
+	if userID != 0 {
+		rev.UserID = &userID
+	}
+	err := tx.Model(&models.BookRevision{}).Where("book_id = ?", id).
+		Select("coalesce(max(revision), 0) + 1").Scan(&rev.Revision).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&rev).Error
+}
+
+func snapshot(b *models.Book) *models.BookSnapshot {
+	if b == nil {
+		return nil
+	}
+	return &models.BookSnapshot{
+		Title:         b.Title,
+		ISBN13:        b.ISBN13,
+		ISBN10:        b.ISBN10,
+		Authors:       snapshotTerms(b.Authors, func(a models.Author) models.Term { return a.Term }),
+		Categories:    snapshotTerms(b.Categories, func(c models.Category) models.Term { return c.Term }),
+		Publishers:    snapshotTerms(b.Publishers, func(p models.Publisher) models.Term { return p.Term }),
+		Description:   b.Description,
+		PageCount:     b.PageCount,
+		PublishedDate: b.PublishedDate,
+		Price:         b.Price,
+		Stock:         b.Stock,
+		CoverURL:      b.CoverURL,
+	}
+}
+
+func snapshotTerms[T any](list []T, term func(T) models.Term) []models.SnapshotTerm {
+	out := make([]models.SnapshotTerm, len(list))
+	for i, t := range list {
+		out[i] = models.SnapshotTerm{ID: term(t).ID, Name: term(t).Name}
+	}
+	return out
+}
+
+func snapshotNames(list []models.SnapshotTerm) []string {
+	return termNames(list, func(t models.SnapshotTerm) string { return t.Name })
+}
+
+func ptrValue[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// snapshotFields are the fields compared between revisions, in the order
+// changes are listed.
+var snapshotFields = []struct {
+	name  string
+	value func(*models.BookSnapshot) any
+}{
+	{"title", func(s *models.BookSnapshot) any { return s.Title }},
+	{"isbn13", func(s *models.BookSnapshot) any { return ptrValue(s.ISBN13) }},
+	{"isbn10", func(s *models.BookSnapshot) any { return ptrValue(s.ISBN10) }},
+	{"authors", func(s *models.BookSnapshot) any { return snapshotNames(s.Authors) }},
+	{"categories", func(s *models.BookSnapshot) any { return snapshotNames(s.Categories) }},
+	{"publishers", func(s *models.BookSnapshot) any { return snapshotNames(s.Publishers) }},
+	{"description", func(s *models.BookSnapshot) any { return s.Description }},
+	{"pageCount", func(s *models.BookSnapshot) any { return ptrValue(s.PageCount) }},
+	{"publishedDate", func(s *models.BookSnapshot) any { return s.PublishedDate }},
+	{"price", func(s *models.BookSnapshot) any { return s.Price }},
+	{"stock", func(s *models.BookSnapshot) any { return s.Stock }},
+	{"coverUrl", func(s *models.BookSnapshot) any { return s.CoverURL }},
+}
+
+// changes lists the fields rev changed. A create lists the fields it set;
+// delete and restore change no field.
+func changes(rev models.BookRevision) []api.FieldChange {
+	before := rev.Before
+	if before == nil && rev.Action == models.RevisionCreate {
+		before = &models.BookSnapshot{Authors: []models.SnapshotTerm{}, Categories: []models.SnapshotTerm{}, Publishers: []models.SnapshotTerm{}}
+	}
+	out := []api.FieldChange{}
+	if before == nil || rev.After == nil {
+		return out
+	}
+	for _, f := range snapshotFields {
+		from, to := f.value(before), f.value(rev.After)
+		if !reflect.DeepEqual(from, to) {
+			out = append(out, api.FieldChange{Field: f.name, From: from, To: to})
+		}
+	}
+	return out
+}
+
+// restoreTerms returns the terms of a snapshot as they are now: by id,
+// or by name (created if need be) for the ones deleted since.
+func restoreTerms(repo *catalog.Repository, list []models.SnapshotTerm) ([]models.Term, error) {
+	out := make([]models.Term, 0, len(list))
+	for _, st := range list {
+		t, err := repo.ByID(st.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			var found []models.Term
+			if found, err = repo.Resolve(nil, []string{st.Name}); err == nil {
+				t = &found[0]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *t)
+	}
+	return out, nil
+}
+
+// bookID reads the :id path param of book routes that need it numeric.
+func bookID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	return uint(id), err == nil
+}
+
+// load reads the :id book, answering 404 if there is none.
+func (h *Handler) load(c *gin.Context) (models.Book, bool) {
+	id, ok := bookID(c)
+	if !ok {
+		api.Fail(c, 404, "book not found")
+		return models.Book{}, false
+	}
+	b, err := h.repo.ByID(id)
+	if err != nil {
+		api.Abort(c, api.NotFound(err, "book not found"))
+		return models.Book{}, false
+	}
+	return b, true
+}
+
+// history godoc
+// @Summary Change history of a book
+// @Description Revisions newest first, each with who made it, the book before and after, and changes: the fields that differ (for a create, the fields it set).
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   id    path  int true  "Book ID"
+// @Param   page  query int false "Page number" default(1)
+// @Param   limit query int false "Page size (1-100)" default(10)
+// @Success 200 {object} api.PagedHistory
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /books/{id}/history [get]
+func (h *Handler) History(c *gin.Context) {
+	b, ok := h.load(c)
+	if !ok {
+		return
+	}
+	page, limit := pageParams(c)
+	revs, total, err := h.repo.History(b.ID, page, limit)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	items := make([]api.BookHistoryEntry, len(revs))
+	for i, rev := range revs {
+		items[i] = api.BookHistoryEntry{BookRevision: rev, Changes: changes(rev)}
+	}
+	api.OK(c, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// revert godoc
+// @Summary Revert a book to a revision
+// @Description Sets the book back to how it was right after the given revision. The revert is recorded as a new revision.
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   id       path int true "Book ID"
+// @Param   revision path int true "Revision number"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "another book has the revision's ISBN"
+// @Failure 412 {object} api.ErrorResponse "the book changed while reverting"
+// @Router  /books/{id}/revert/{revision} [post]
+func (h *Handler) Revert(c *gin.Context) {
+	b, ok := h.load(c)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		api.Fail(c, 404, "revision not found")
+		return
+	}
+	rev, err := h.repo.Revision(b.ID, n)
+	if err != nil {
+		api.Abort(c, api.NotFound(err, "revision not found"))
+		return
+	}
+	s := rev.After
+	if s == nil {
+		api.Fail(c, 400, fmt.Sprintf("revision %d deleted the book; revert to an earlier one", n))
+		return
+	}
+
+	b.Title, b.ISBN13, b.ISBN10 = s.Title, s.ISBN13, s.ISBN10
+	b.Description, b.PageCount, b.PublishedDate = s.Description, s.PageCount, s.PublishedDate
+	b.Price, b.Stock = s.Price, s.Stock
+	// a cover replaced longer ago than the trash retention has been swept
+	if !h.coverGone(s.CoverURL) {
+		b.CoverURL = s.CoverURL
+	}
+	for _, f := range []struct {
+		assoc string
+		repo  *catalog.Repository
+		terms []models.SnapshotTerm
+	}{
+		{"Authors", h.terms.Authors, s.Authors},
+		{"Categories", h.terms.Categories, s.Categories},
+		{"Publishers", h.terms.Publishers, s.Publishers},
+	} {
+		terms, err := restoreTerms(f.repo, f.terms)
+		if err != nil {
+			api.Abort(c, err)
+			return
+		}
+		setTerms(&b, f.assoc, terms)
+	}
+
+	uid, _ := auth.GetUserID(c)
+	err = h.repo.Revert(&b, uid)
+	if errors.Is(err, ErrStale) {
+		failStale(c)
+		return
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		api.Fail(c, 409, "another book now has this revision's ISBN")
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	c.Header("ETag", etag(&b))
+	api.OK(c, b)
+}
@@ -0,0 +1,85 @@
+package books
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+func TestRenameRecordsRevisions(t *testing.T) {
+	h, r := newTestHandler(t)
+	dune, _ := createBook(t, r, `{"title":"Dune","authors":["Frank Herbrt"]}`)
+	messiah, _ := createBook(t, r, `{"title":"Dune Messiah","authors":["Frank Herbrt","Brian Herbert"]}`)
+	trashed, tag := createBook(t, r, `{"title":"Children of Dune","authors":["Frank Herbrt"]}`)
+	if w := serve(r, "DELETE", fmt.Sprintf("/books/%d", trashed.ID), nil, "If-Match", tag); w.Code != 200 {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	author, err := h.terms.Authors.ByName("Frank Herbrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.terms.Authors.Rename(author, "Frank Herbert", h.repo, 7); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []models.Book{dune, messiah} {
+		revs, total, err := h.repo.History(b.ID, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 {
+			t.Fatalf("%s has %d revisions, want 2", b.Title, total)
+		}
+		rev := revs[0]
+		if rev.Action != models.RevisionUpdate || rev.Revision != 2 || rev.UserID == nil || *rev.UserID != 7 {
+			t.Errorf("%s: revision %d %s by %v", b.Title, rev.Revision, rev.Action, rev.UserID)
+		}
+		ch := changes(rev)
+		if len(ch) != 1 || ch[0].Field != "authors" {
+			t.Fatalf("%s: changes = %+v, want authors", b.Title, ch)
+		}
+		if to := ch[0].To.([]string); !slices.Contains(to, "Frank Herbert") || slices.Contains(to, "Frank Herbrt") {
+			t.Errorf("%s: authors now %v", b.Title, to)
+		}
+		if got, _ := h.repo.ByID(b.ID); got.Version != b.Version+1 {
+			t.Errorf("%s: version %d, want %d", b.Title, got.Version, b.Version+1)
+		}
+	}
+	if _, total, _ := h.repo.History(trashed.ID, 1, 10); total != 2 {
+		t.Errorf("trashed book has %d revisions, want 2 (create, delete)", total)
+	}
+}
+
+type failingHistory struct{}
+
+var errHistory = errors.New("history unavailable")
+
+func (failingHistory) RecordChange(tx *gorm.DB, ids []uint, userID uint, change func() error) error {
+	if err := change(); err != nil {
+		return err
+	}
+	return errHistory
+}
+
+func TestRenameRollsBackWithoutRevisions(t *testing.T) {
+	h, r := newTestHandler(t)
+	b, tag := createBook(t, r, `{"title":"Dune","authors":["Frank Herbrt"]}`)
+	author, err := h.terms.Authors.ByName("Frank Herbrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.terms.Authors.Rename(author, "Frank Herbert", failingHistory{}, 0); !errors.Is(err, errHistory) {
+		t.Fatalf("Rename err = %v, want %v", err, errHistory)
+	}
+	if _, err := h.terms.Authors.ByName("Frank Herbrt"); err != nil {
+		t.Errorf("old name gone after a failed rename: %v", err)
+	}
+	w := serve(r, "GET", fmt.Sprintf("/books/%d", b.ID), nil, "If-None-Match", tag)
+	if w.Code != 304 {
+		t.Errorf("book changed by a failed rename: %d %s", w.Code, w.Body)
+	}
+}
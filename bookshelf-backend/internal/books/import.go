@@ -0,0 +1,337 @@
+package books
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+const (
+	maxImportBytes     = 32 << 20
+	defaultImportBatch = 500
+	maxImportBatch     = 5000
+)
+
+// importFormat picks csv or jsonl from the format field, else from the
+// file extension.
+func importFormat(c *gin.Context, filename string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(c.PostForm("format")))
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		}
+	}
+	if format != "csv" && format != "jsonl" {
+		return "", errors.New("format must be csv or jsonl")
+	}
+	return format, nil
+}
+
+// importBooks godoc
+// @Summary Import books from CSV or JSON Lines
+// @Description CSV files need a header row; JSON Lines files hold one object per line. Columns (or keys) named like the book fields are used as they are: title, isbn, authors, categories, publishers, price, stock, description, pageCount, publishedDate. mapping renames them, e.g. {"title":"Book Title","authors":"Writer"}. Several names in authors, categories or publishers are separated by ";" (JSON arrays work too).
+// @Description A row whose ISBN is already on the shelf updates that book, only changing the cells that aren't empty; other rows create books and need a title. Rows are written in transactions of batchSize; a bad row is skipped and reported without affecting the rest. With dryRun=true the rows are only checked. The report is stored and its failed rows can be downloaded from /books/import/{id}/errors.csv.
+// @Tags    books
+// @Accept  multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param   file      formData file    true  "CSV or JSON Lines file (max 32 MB)"
+// @Param   format    formData string  false "csv or jsonl, by default from the file extension"
+// @Param   mapping   formData string  false "JSON object of book field to column or key"
+// @Param   dryRun    formData bool    false "Only validate"
+// @Param   batchSize formData integer false "Rows per transaction (1-5000)" default(500)
+// @Success 201 {object} models.ImportReport
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Router  /books/import [post]
+func (h *Handler) Import(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		api.Fail(c, 400, "file is required")
+		return
+	}
+	if fh.Size > maxImportBytes {
+		api.Fail(c, 400, fmt.Sprintf("file is larger than %d MB", maxImportBytes>>20))
+		return
+	}
+	format, err := importFormat(c, fh.Filename)
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+	mapping := map[string]string{}
+	if s := strings.TrimSpace(c.PostForm("mapping")); s != "" {
+		if err := json.Unmarshal([]byte(s), &mapping); err != nil {
+			api.Fail(c, 400, "mapping must be a JSON object of field to column")
+			return
+		}
+		if err := checkMapping(mapping); err != nil {
+			api.Fail(c, 400, err.Error())
+			return
+		}
+	}
+	batch := defaultImportBatch
+	if s := c.PostForm("batchSize"); s != "" {
+		if batch, err = strconv.Atoi(s); err != nil || batch < 1 || batch > maxImportBatch {
+			api.Fail(c, 400, fmt.Sprintf("batchSize must be between 1 and %d", maxImportBatch))
+			return
+		}
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to read file"))
+		return
+	}
+	defer f.Close()
+	var rows []sourceRow
+	if format == "csv" {
+		rows, err = readCSV(f, mapping)
+	} else {
+		rows, err = readJSONL(f, mapping)
+	}
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return
+	}
+
+	uid, _ := auth.GetUserID(c)
+	rep := models.ImportReport{
+		UserID:   uid,
+		Filename: fh.Filename,
+		Format:   format,
+		DryRun:   c.PostForm("dryRun") == "true",
+		Rows:     len(rows),
+		Errors:   []models.ImportRowError{},
+	}
+	h.runImport(rows, batch, &rep)
+	if err := h.repo.CreateImportReport(&rep); err != nil {
+		api.Abort(c, api.Internal(err, "failed to store import report"))
+		return
+	}
+	log.Printf("📥 Import %d by user %d: %d rows, %d created, %d updated, %d failed (dry run: %t)",
+		rep.ID, uid, rep.Rows, rep.Created, rep.Updated, rep.Failed, rep.DryRun)
+	api.Created(c, rep)
+}
+
+// runImport writes rows in transactions of batch rows and fills in rep.
+func (h *Handler) runImport(rows []sourceRow, batch int, rep *models.ImportReport) {
+	fail := func(row sourceRow, err error) {
+		e := models.ImportRowError{Row: row.line, Error: err.Error(), Record: row.record}
+		var ie *importError
+		if errors.As(err, &ie) {
+			e.Field = ie.Field
+		}
+		rep.Errors = append(rep.Errors, e)
+		rep.Failed++
+	}
+	// in a dry run, the books earlier rows would have stored, by ISBN
+	stored := map[string]models.Book{}
+
+	for start := 0; start < len(rows); start += batch {
+		chunk := rows[start:min(start+batch, len(rows))]
+		var done []importRow
+		var created []bool
+		process := func(tx *gorm.DB) error {
+			for _, src := range chunk {
+				row, err := parseRow(src)
+				if err != nil {
+					fail(src, err)
+					continue
+				}
+				var isNew bool
+				if rep.DryRun {
+					isNew, err = h.checkRow(row, stored)
+				} else {
+					// a savepoint, so a failing row leaves the batch usable
+					err = tx.Transaction(func(stx *gorm.DB) error {
+						var err error
+						isNew, err = h.upsertRow(stx, row, rep.UserID)
+						return err
+					})
+				}
+				if err != nil {
+					fail(src, err)
+					continue
+				}
+				done, created = append(done, row), append(created, isNew)
+			}
+			return nil
+		}
+		var err error
+		if rep.DryRun {
+			err = process(nil)
+		} else {
+			err = h.repo.Transaction(process)
+		}
+		if err != nil {
+			for _, row := range done {
+				fail(row.sourceRow, fmt.Errorf("batch rolled back: %w", err))
+			}
+			continue
+		}
+		for _, isNew := range created {
+			if isNew {
+				rep.Created++
+			} else {
+				rep.Updated++
+			}
+		}
+	}
+	slices.SortStableFunc(rep.Errors, func(a, b models.ImportRowError) int { return a.Row - b.Row })
+}
+
+// checkRow reports whether row would create a book, without writing.
+// stored has the books earlier rows of the dry run would have stored.
+func (h *Handler) checkRow(row importRow, stored map[string]models.Book) (bool, error) {
+	var b models.Book
+	isNew := true
+	if row.isbn13 != nil {
+		if prev, ok := stored[*row.isbn13]; ok {
+			b, isNew = prev, false
+		} else if existing, err := h.repo.ByISBN(*row.isbn13); err == nil {
+			b, isNew = existing, false
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+	}
+	if err := row.applyChecked(&b, isNew); err != nil {
+		return false, err
+	}
+	if row.isbn13 != nil {
+		stored[*row.isbn13] = b
+	}
+	return isNew, nil
+}
+
+// upsertRow creates the book of row, or updates the one with its ISBN,
+// on behalf of userID and reports whether it was created.
+func (h *Handler) upsertRow(tx *gorm.DB, row importRow, userID uint) (bool, error) {
+	repo, terms := h.repo.WithDB(tx), h.terms.withDB(tx)
+	var b models.Book
+	isNew := true
+	if row.isbn13 != nil {
+		existing, err := repo.ByISBN(*row.isbn13)
+		if err == nil {
+			b, isNew = existing, false
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+	}
+	if err := row.applyChecked(&b, isNew); err != nil {
+		return false, err
+	}
+
+	var links []string
+	for _, f := range termFields {
+		names, ok := row.terms[f.assoc]
+		if !ok {
+			continue
+		}
+		resolved, err := f.repo(terms).Resolve(nil, names)
+		if err != nil {
+			return false, err
+		}
+		setTerms(&b, f.assoc, resolved)
+		links = append(links, f.assoc)
+	}
+
+	if isNew {
+		return true, repo.Create(&b, userID)
+	}
+	return false, repo.Save(&b, userID, links...)
+}
+
+func (h *Handler) loadImportReport(c *gin.Context) (models.ImportReport, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		api.Fail(c, 404, "import report not found")
+		return models.ImportReport{}, false
+	}
+	rep, err := h.repo.ImportReport(uint(id))
+	if err != nil {
+		api.Abort(c, api.NotFound(err, "import report not found"))
+		return models.ImportReport{}, false
+	}
+	return rep, true
+}
+
+// importReport godoc
+// @Summary Get an import report
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "Report ID"
+// @Success 200 {object} models.ImportReport
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /books/import/{id} [get]
+func (h *Handler) ImportReport(c *gin.Context) {
+	if rep, ok := h.loadImportReport(c); ok {
+		api.OK(c, rep)
+	}
+}
+
+// importErrors godoc
+// @Summary Download the failed rows of an import
+// @Description CSV with the row number, field and error, followed by the row's own columns, ready to be fixed and imported again.
+// @Tags    books
+// @Produce text/csv
+// @Security BearerAuth
+// @Param   id path int true "Report ID"
+// @Success 200 {file} file
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /books/import/{id}/errors.csv [get]
+func (h *Handler) ImportErrors(c *gin.Context) {
+	rep, ok := h.loadImportReport(c)
+	if !ok {
+		return
+	}
+	var cols []string
+	for _, e := range rep.Errors {
+		for k := range e.Record {
+			if !slices.Contains(cols, k) {
+				cols = append(cols, k)
+			}
+		}
+	}
+	slices.Sort(cols)
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%d-errors.csv"`, rep.ID))
+	w := csv.NewWriter(c.Writer)
+	// the cells come from the uploaded file, so they are escaped like an
+	// export's
+	header := []string{"row", "field", "error"}
+	for _, k := range cols {
+		header = append(header, csvText(k))
+	}
+	_ = w.Write(header)
+	for _, e := range rep.Errors {
+		line := []string{strconv.Itoa(e.Row), csvText(e.Field), csvText(e.Error)}
+		for _, k := range cols {
+			line = append(line, csvText(e.Record[k]))
+		}
+		_ = w.Write(line)
+	}
+	w.Flush()
+}
@@ -0,0 +1,316 @@
+package books
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/isbn"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+// importFields are the book fields import columns map to. By default a
+// column maps to the field of the same name, ignoring case.
+var importFields = []string{
+	"title", "isbn", "authors", "categories", "publishers",
+	"price", "stock", "description", "pageCount", "publishedDate",
+}
+
+// listSep separates the names in authors, categories and publishers cells.
+const listSep = ";"
+
+// sourceRow is one record of an import file. values holds the mapped
+// cells by field, record the whole row by source column for the report.
+type sourceRow struct {
+	line   int
+	values map[string]string
+	record map[string]string
+	err    error // the row could not be read
+}
+
+// importError is a problem with one field of a row.
+type importError struct {
+	Field string
+	Msg   string
+}
+
+func (e *importError) Error() string { return e.Msg }
+
+// checkMapping validates a field -> source column mapping.
+func checkMapping(mapping map[string]string) error {
+	for field := range mapping {
+		if !slices.Contains(importFields, field) {
+			return fmt.Errorf("mapping: unknown field %q (want one of %s)", field, strings.Join(importFields, ", "))
+		}
+	}
+	return nil
+}
+
+// readCSV reads a CSV file with a header row.
+func readCSV(r io.Reader, mapping map[string]string) ([]sourceRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	col := map[string]int{} // field -> column index
+	for _, field := range importFields {
+		name, mapped := mapping[field]
+		if !mapped {
+			name = field
+		}
+		found := false
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				col[field], found = i, true
+				break
+			}
+		}
+		if mapped && !found {
+			return nil, fmt.Errorf("mapping: no column %q for %s", name, field)
+		}
+	}
+	if _, ok := col["title"]; !ok {
+		if _, ok := col["isbn"]; !ok {
+			return nil, errors.New("the file needs a title or an isbn column")
+		}
+	}
+
+	var rows []sourceRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return nil, fmt.Errorf("CSV line %d: %v", pe.Line, pe.Err)
+			}
+			return nil, err
+		}
+		row := sourceRow{line: line, values: map[string]string{}, record: map[string]string{}}
+		for i, v := range rec {
+			if i < len(header) {
+				row.record[header[i]] = v
+			}
+		}
+		for field, i := range col {
+			if i < len(rec) {
+				row.values[field] = strings.TrimSpace(rec[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readJSONL reads one JSON object per line. Arrays become listSep joined
+// strings; a line that isn't an object is reported as a failed row.
+func readJSONL(r io.Reader, mapping map[string]string) ([]sourceRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var rows []sourceRow
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		row := sourceRow{line: line, values: map[string]string{}, record: map[string]string{}}
+		var obj map[string]any
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			row.record["_line"] = string(text)
+			row.err = &importError{Msg: "not a JSON object: " + err.Error()}
+			rows = append(rows, row)
+			continue
+		}
+		for k, v := range obj {
+			row.record[k] = jsonCell(v)
+		}
+		for _, field := range importFields {
+			key, mapped := mapping[field]
+			if !mapped {
+				key = field
+			}
+			if v, ok := row.record[key]; ok {
+				row.values[field] = strings.TrimSpace(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read JSON Lines: %w", err)
+	}
+	return rows, nil
+}
+
+func jsonCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, x := range v {
+			parts = append(parts, jsonCell(x))
+		}
+		return strings.Join(parts, listSep)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// importRow is a validated row. Nil fields were empty and leave an
+// existing book unchanged.
+type importRow struct {
+	sourceRow
+	title         string
+	isbn13        *string
+	terms         map[string][]string // by association, e.g. "Authors"
+	price         *float64
+	stock         *int
+	description   *string
+	pageCount     *int
+	publishedDate *string
+}
+
+// parseRow validates the cells of r.
+func parseRow(r sourceRow) (importRow, error) {
+	row := importRow{sourceRow: r, terms: map[string][]string{}}
+	if r.err != nil {
+		return row, r.err
+	}
+	v := r.values
+	row.title = v["title"]
+	if s := v["isbn"]; s != "" {
+		s13, err := isbn.Normalize(s)
+		if err != nil {
+			return row, &importError{"isbn", err.Error()}
+		}
+		row.isbn13 = &s13
+	}
+	for field, assoc := range map[string]string{"authors": "Authors", "categories": "Categories", "publishers": "Publishers"} {
+		if s := v[field]; s != "" {
+			var names []string
+			for _, n := range strings.Split(s, listSep) {
+				if n = strings.TrimSpace(n); n != "" {
+					names = append(names, n)
+				}
+			}
+			row.terms[assoc] = names
+		}
+	}
+	if s := v["price"]; s != "" {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return row, &importError{"price", "price must be a non-negative number"}
+		}
+		row.price = &f
+	}
+	var err error
+	if row.stock, err = intCell(v, "stock"); err != nil {
+		return row, err
+	}
+	if row.pageCount, err = intCell(v, "pageCount"); err != nil {
+		return row, err
+	}
+	if s := v["description"]; s != "" {
+		row.description = &s
+	}
+	if s := v["publishedDate"]; s != "" {
+		row.publishedDate = &s
+	}
+	return row, nil
+}
+
+func intCell(v map[string]string, field string) (*int, error) {
+	s := v[field]
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, &importError{field, field + " must be a non-negative integer"}
+	}
+	return &n, nil
+}
+
+// applyChecked applies row to b, the stored book it updates or a new one,
+// and checks the result as the API checks a book.
+func (row importRow) applyChecked(b *models.Book, isNew bool) error {
+	row.apply(b)
+	if isNew && b.Title == "" {
+		return &importError{"title", "title is required for a new book"}
+	}
+	fields := api.Validate(b)
+	if len(fields) == 0 {
+		return nil
+	}
+	// the first bad column, in a stable order; the book calls isbn isbn13
+	for _, f := range importFields {
+		name := f
+		if f == "isbn" {
+			name = "isbn13"
+		}
+		if msg, ok := fields[name]; ok {
+			return &importError{f, f + " " + msg}
+		}
+	}
+	for name, msg := range fields {
+		return &importError{name, name + " " + msg}
+	}
+	return nil
+}
+
+// apply copies the non-empty fields of row onto b (not the terms).
+func (row importRow) apply(b *models.Book) {
+	if row.title != "" {
+		b.Title = row.title
+	}
+	if row.isbn13 != nil {
+		b.ISBN13 = row.isbn13
+		b.ISBN10 = nil
+		if s10, ok := isbn.To10(*row.isbn13); ok {
+			b.ISBN10 = &s10
+		}
+	}
+	if row.price != nil {
+		b.Price = *row.price
+	}
+	if row.stock != nil {
+		b.Stock = *row.stock
+	}
+	if row.description != nil {
+		b.Description = *row.description
+	}
+	if row.pageCount != nil {
+		b.PageCount = row.pageCount
+	}
+	if row.publishedDate != nil {
+		b.PublishedDate = *row.publishedDate
+	}
+}
@@ -0,0 +1,123 @@
+package books
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestParseRow(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		field  string // of the error, "" for none
+	}{
+		{"valid", map[string]string{"title": "Dune", "isbn": "0-441-01359-7", "price": "9.5", "stock": "3"}, ""},
+		{"bad isbn", map[string]string{"title": "Dune", "isbn": "123"}, "isbn"},
+		{"negative price", map[string]string{"price": "-1"}, "price"},
+		{"NaN price", map[string]string{"price": "NaN"}, "price"},
+		{"infinite price", map[string]string{"price": "+Inf"}, "price"},
+		{"fractional stock", map[string]string{"stock": "1.5"}, "stock"},
+		{"negative page count", map[string]string{"pageCount": "-3"}, "pageCount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRow(sourceRow{line: 2, values: tt.values})
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			var ie *importError
+			if !errors.As(err, &ie) || ie.Field != tt.field {
+				t.Fatalf("err = %v, want an error on %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestApplyChecked(t *testing.T) {
+	long := strings.Repeat("x", 501)
+	tests := []struct {
+		name  string
+		title string
+		base  *models.Book // nil for a new book
+		field string
+		msg   string
+	}{
+		{"new book", "Dune", nil, "", ""},
+		{"new book without title", "", nil, "title", "title is required for a new book"},
+		{"update keeps the stored title", "", &models.Book{Title: "Dune"}, "", ""},
+		{"title too long", long, nil, "title", "title must be at most 500 characters"},
+		{"title too long on update", long, &models.Book{Title: "Dune"}, "title", "title must be at most 500 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b models.Book
+			if tt.base != nil {
+				b = *tt.base
+			}
+			err := importRow{title: tt.title}.applyChecked(&b, tt.base == nil)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			var ie *importError
+			if !errors.As(err, &ie) || ie.Field != tt.field || ie.Msg != tt.msg {
+				t.Fatalf("err = %#v, want %s: %q", err, tt.field, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCheckRowUsesEarlierRows(t *testing.T) {
+	h, _ := newTestHandler(t)
+	s13 := "9780441013593"
+	stored := map[string]models.Book{}
+
+	// the first row creates the book, the second updates it without a title
+	if isNew, err := h.checkRow(importRow{title: "Dune", isbn13: &s13}, stored); err != nil || !isNew {
+		t.Fatalf("first row = (%v, %v), want new", isNew, err)
+	}
+	stock := 4
+	if isNew, err := h.checkRow(importRow{isbn13: &s13, stock: &stock}, stored); err != nil || isNew {
+		t.Fatalf("second row = (%v, %v), want update", isNew, err)
+	}
+	if b := stored[s13]; b.Title != "Dune" || b.Stock != 4 {
+		t.Errorf("stored book = %+v", b)
+	}
+}
+
+func TestImportErrorsEscapesFormulas(t *testing.T) {
+	h, r := newTestHandler(t)
+	rep := models.ImportReport{UserID: 1, Filename: "books.csv", Format: "csv", Errors: []models.ImportRowError{{
+		Row: 2, Field: "price", Error: "price must be a number",
+		Record: map[string]string{"title": `=HYPERLINK("http://evil.test","Dune")`, "price": "-1+2", "=cmd": "@SUM(A1)", "isbn": "9780441013593"},
+	}}}
+	if err := h.repo.CreateImportReport(&rep); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(r, "GET", fmt.Sprintf("/books/import/%d/errors.csv", rep.ID), nil)
+	if w.Code != 200 {
+		t.Fatalf("errors.csv = %d %s", w.Code, w.Body)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"row", "field", "error", "'=cmd", "isbn", "price", "title"},
+		{"2", "price", "price must be a number", "'@SUM(A1)", "9780441013593", "'-1+2", `'=HYPERLINK("http://evil.test","Dune")`},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("errors.csv =\n%q\nwant\n%q", rows, want)
+	}
+}
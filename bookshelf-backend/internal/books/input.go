@@ -0,0 +1,427 @@
+package books
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/isbn"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+// bookInput is a book payload, from form fields or a JSON body. sent
+// holds the fields the request has, by their JSON name; a nil value
+// clears the field. errs collects what is wrong, by the name the field
+// was sent under.
+type bookInput struct {
+	sent          map[string]bool
+	title         *string
+	isbn          *string
+	description   *string
+	publishedDate *string
+	pageCount     *int
+	price         *float64
+	stock         *int
+	terms         map[string]termInput // by association, e.g. "Authors"
+	isbnField     string               // "isbn" in forms, "isbn13" in JSON
+	errs          map[string]string
+}
+
+// termInput gives the terms to link by id and by name.
+type termInput struct {
+	ids   []uint
+	names []string
+}
+
+func newInput(isbnField string) *bookInput {
+	return &bookInput{sent: map[string]bool{}, terms: map[string]termInput{}, isbnField: isbnField, errs: map[string]string{}}
+}
+
+// BookBody is the JSON form of a book payload (used in Swagger). Links go
+// by id or name, or as the {id, name} objects GET returns. The read-only
+// members of a book (id, isbn10, coverUrl, version, timestamps) may be
+// sent back and are ignored.
+type BookBody struct {
+	Title         *string  `json:"title"         example:"1984"`
+	ISBN13        *string  `json:"isbn13"        example:"978-0-451-52493-5"`
+	Authors       []any    `json:"authors"       swaggertype:"array,string" example:"George Orwell"`
+	Categories    []any    `json:"categories"    swaggertype:"array,string" example:"Fiction"`
+	Publishers    []any    `json:"publishers"    swaggertype:"array,string" example:"Secker & Warburg"`
+	Description   *string  `json:"description"   example:"A dystopian novel."`
+	PageCount     *int     `json:"pageCount"     example:"328"`
+	PublishedDate *string  `json:"publishedDate" example:"1949"`
+	Price         *float64 `json:"price"         example:"60000"`
+	Stock         *int     `json:"stock"         example:"10"`
+}
+
+// writable are the members of a book JSON body that can be set.
+var writable = []string{"title", "isbn13", "authors", "categories", "publishers",
+	"description", "pageCount", "publishedDate", "price", "stock"}
+
+// readOnly are members of a book as GET returns it that a body may carry
+// but that can't be written.
+var readOnly = map[string]bool{"id": true, "isbn10": true, "coverUrl": true, "version": true,
+	"createdAt": true, "updatedAt": true, "deletedAt": true, "highlight": true}
+
+// isJSON reports whether the request body is JSON, including
+// application/merge-patch+json.
+func isJSON(c *gin.Context) bool {
+	t, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
+
+// readInput reads a POST or PUT payload: a JSON body, which replaces the
+// book, or form fields, which change only the fields present. It answers
+// 400 for a body that is not a JSON object.
+func readInput(c *gin.Context) (*bookInput, bool) {
+	if !isJSON(c) {
+		return formInput(c), true
+	}
+	in, err := jsonInput(c.Request.Body, true)
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return nil, false
+	}
+	return in, true
+}
+
+// formInput reads the book fields of a multipart or urlencoded form. A
+// field sent empty clears it.
+func formInput(c *gin.Context) *bookInput {
+	in := newInput("isbn")
+	for _, f := range []struct {
+		field, key string
+		dst        **string
+	}{
+		{"title", "title", &in.title},
+		{"isbn13", "isbn", &in.isbn},
+		{"description", "description", &in.description},
+		{"publishedDate", "publishedDate", &in.publishedDate},
+	} {
+		if s, ok := c.GetPostForm(f.key); ok {
+			in.sent[f.field] = true
+			*f.dst = &s
+		}
+	}
+	in.pageCount = formNumber(c, in, "pageCount", strconv.Atoi, "must be an integer")
+	in.price = formNumber(c, in, "price", func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }, "must be a number")
+	in.stock = formNumber(c, in, "stock", strconv.Atoi, "must be an integer")
+	if file, err := c.FormFile("cover"); err == nil && file != nil {
+		if _, ok := uploadExt(file.Filename); !ok {
+			in.errs["cover"] = "must be .jpg/.jpeg/.png/.webp"
+		}
+	}
+
+	for _, f := range termFields {
+		rawIDs, hasIDs := c.GetPostFormArray(f.ids)
+		names, hasNames := c.GetPostFormArray(f.names)
+		if v := strings.TrimSpace(c.PostForm(f.legacy)); v != "" {
+			names, hasNames = append(names, v), true
+		}
+		if !hasIDs && !hasNames {
+			continue
+		}
+		t := termInput{names: names}
+		for _, raw := range rawIDs {
+			for _, s := range strings.Split(raw, ",") {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				id, err := strconv.ParseUint(s, 10, 64)
+				if err != nil {
+					in.errs[f.ids] = "must be numeric ids"
+					continue
+				}
+				t.ids = append(t.ids, uint(id))
+			}
+		}
+		in.sent[f.names] = true
+		in.terms[f.assoc] = t
+	}
+	return in
+}
+
+// formNumber parses form field key, if sent; empty gives nil.
+func formNumber[T any](c *gin.Context, in *bookInput, key string, parse func(string) (T, error), msg string) *T {
+	s, ok := c.GetPostForm(key)
+	if !ok {
+		return nil
+	}
+	in.sent[key] = true
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+	v, err := parse(s)
+	if err != nil {
+		in.errs[key] = msg
+		return nil
+	}
+	return &v
+}
+
+// jsonInput reads a JSON object. With replace (POST, PUT) the writable
+// members left out count as null, so they are cleared; otherwise (PATCH,
+// RFC 7396 merge patch) only the members present change, null clearing.
+func jsonInput(body io.Reader, replace bool) (*bookInput, error) {
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&members); err != nil || members == nil {
+		return nil, errors.New("body must be a JSON object")
+	}
+	if replace {
+		for _, name := range writable {
+			if _, ok := members[name]; !ok {
+				members[name] = nil
+			}
+		}
+	}
+
+	in := newInput("isbn13")
+	for name, raw := range members {
+		if readOnly[name] {
+			continue
+		}
+		var err error
+		switch name {
+		case "title":
+			in.title, err = jsonValue[string](raw, "must be a string")
+		case "isbn13":
+			in.isbn, err = jsonValue[string](raw, "must be a string")
+		case "description":
+			in.description, err = jsonValue[string](raw, "must be a string")
+		case "publishedDate":
+			in.publishedDate, err = jsonValue[string](raw, "must be a string")
+		case "pageCount":
+			in.pageCount, err = jsonValue[int](raw, "must be an integer")
+		case "price":
+			in.price, err = jsonValue[float64](raw, "must be a number")
+		case "stock":
+			in.stock, err = jsonValue[int](raw, "must be an integer")
+		case "authors", "categories", "publishers":
+			var refs *[]termRef
+			refs, err = jsonValue[[]termRef](raw, "must be a list of ids, names or {id, name} objects")
+			if err == nil {
+				in.terms[termFieldNamed(name).assoc] = termsOf(refs)
+			}
+		default:
+			in.errs[name] = "unknown field"
+			continue
+		}
+		in.sent[name] = true
+		if err != nil {
+			in.errs[name] = err.Error()
+		}
+	}
+	return in, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return raw == nil || bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// jsonValue decodes a member; null gives nil.
+func jsonValue[T any](raw json.RawMessage, msg string) (*T, error) {
+	if isNull(raw) {
+		return nil, nil
+	}
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, errors.New(msg)
+	}
+	return &v, nil
+}
+
+// termRef is a linked term in a JSON body: an id (3 or {"id": 3}) or a
+// name ("Fiction" or {"name": "Fiction"}).
+type termRef struct {
+	ID   uint
+	Name string
+}
+
+func (t *termRef) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &t.ID) == nil || json.Unmarshal(data, &t.Name) == nil {
+		return nil
+	}
+	var obj struct {
+		ID   uint   `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil || (obj.ID == 0 && obj.Name == "") {
+		return errors.New("not an id, name or {id, name} object")
+	}
+	t.ID, t.Name = obj.ID, obj.Name
+	return nil
+}
+
+func termsOf(refs *[]termRef) termInput {
+	var t termInput
+	if refs == nil {
+		return t
+	}
+	for _, r := range *refs {
+		if r.ID != 0 {
+			t.ids = append(t.ids, r.ID)
+		} else {
+			t.names = append(t.names, r.Name)
+		}
+	}
+	return t
+}
+
+func termFieldNamed(names string) termField {
+	for _, f := range termFields {
+		if f.names == names {
+			return f
+		}
+	}
+	panic("books: no term field " + names)
+}
+
+func trimmed(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return strings.TrimSpace(*s)
+}
+
+// apply checks in and sets it on b, adding every invalid field to
+// in.errs. Links are only checked; Terms.link sets them when the book is
+// saved.
+func (h *Handler) apply(b *models.Book, in *bookInput) error {
+	if in.sent["title"] {
+		b.Title = trimmed(in.title)
+	}
+	if in.sent["isbn13"] {
+		b.ISBN13, b.ISBN10 = nil, nil
+		if s := trimmed(in.isbn); s != "" {
+			s13, err := isbn.Normalize(s)
+			if err != nil {
+				in.errs[in.isbnField] = err.Error()
+			} else {
+				b.ISBN13 = &s13
+				if s10, ok := isbn.To10(s13); ok {
+					b.ISBN10 = &s10
+				}
+			}
+		}
+	}
+	if in.sent["description"] {
+		b.Description = trimmed(in.description)
+	}
+	if in.sent["publishedDate"] {
+		b.PublishedDate = trimmed(in.publishedDate)
+	}
+	if in.sent["pageCount"] {
+		b.PageCount = in.pageCount
+	}
+	if in.sent["price"] {
+		b.Price = 0
+		if v := in.price; v != nil {
+			if math.IsNaN(*v) || math.IsInf(*v, 0) {
+				in.errs["price"] = "must be a number"
+			} else {
+				b.Price = *v
+			}
+		}
+	}
+	if in.sent["stock"] {
+		b.Stock = 0
+		if v := in.stock; v != nil {
+			b.Stock = *v
+		}
+	}
+	// the book's binding tags; a field that did not even parse keeps
+	// that error
+	for field, msg := range api.Validate(b) {
+		if _, ok := in.errs[field]; !ok {
+			in.errs[field] = msg
+		}
+	}
+
+	// unknown ids are reported with the rest; names are only created once
+	// the whole payload is known to be good
+	for _, f := range termFields {
+		t, ok := in.terms[f.assoc]
+		if !ok {
+			continue
+		}
+		for _, id := range t.ids {
+			_, err := f.repo(h.terms).ByID(id)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				in.errs[f.names] = fmt.Sprintf("unknown %s id %d", f.singular, id)
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// link sets the links of in on b, creating names not seen before, and
+// returns the associations it replaced, for Repository.Save. It runs in
+// the transaction that saves b, so a failed save creates no names.
+func (t Terms) link(b *models.Book, in *bookInput) ([]string, error) {
+	var links []string
+	for _, f := range termFields {
+		ref, ok := in.terms[f.assoc]
+		if !ok {
+			continue
+		}
+		terms, err := f.repo(t).Resolve(ref.ids, ref.names)
+		if err != nil {
+			return nil, err
+		}
+		setTerms(b, f.assoc, terms)
+		links = append(links, f.assoc)
+	}
+	return links, nil
+}
+
+// bind applies in to b, answering 400 with every invalid field, or 409 if
+// another book has the ISBN. It reports whether the request may go on.
+func (h *Handler) bind(c *gin.Context, b *models.Book, in *bookInput) bool {
+	if err := h.apply(b, in); err != nil {
+		api.Abort(c, err)
+		return false
+	}
+	if len(in.errs) > 0 {
+		api.FailFields(c, 400, "invalid book", in.errs)
+		return false
+	}
+	if b.ISBN13 != nil {
+		if other, err := h.repo.ByISBN(*b.ISBN13); err == nil && other.ID != b.ID {
+			api.Fail(c, 409, fmt.Sprintf("book %d already has ISBN %s", other.ID, *b.ISBN13))
+			return false
+		}
+	}
+	return true
+}
+
+// create links b as in says and stores it, in one transaction.
+func (h *Handler) create(b *models.Book, in *bookInput, userID uint) error {
+	return h.repo.Transaction(func(tx *gorm.DB) error {
+		if _, err := h.terms.withDB(tx).link(b, in); err != nil {
+			return err
+		}
+		return h.repo.WithDB(tx).Create(b, userID)
+	})
+}
+
+// save links b as in says and saves it, in one transaction.
+func (h *Handler) save(b *models.Book, in *bookInput, userID uint) error {
+	return h.repo.Transaction(func(tx *gorm.DB) error {
+		links, err := h.terms.withDB(tx).link(b, in)
+		if err != nil {
+			return err
+		}
+		return h.repo.WithDB(tx).Save(b, userID, links...)
+	})
+}
@@ -0,0 +1,134 @@
+package books
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/internal/api"
+)
+
+func TestJSONInput(t *testing.T) {
+	body := `{"title":"Emma","description":null,"authors":["Jane Austen",{"id":3}],
+		"id":99,"version":7,"isbn10":"0000000000","coverUrl":"/uploads/x.jpg","createdAt":"2000-01-01T00:00:00Z"}`
+
+	patch, err := jsonInput(strings.NewReader(body), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch.errs) != 0 {
+		t.Fatalf("errs = %v", patch.errs)
+	}
+	var sent []string
+	for name := range patch.sent {
+		sent = append(sent, name)
+	}
+	slices.Sort(sent)
+	// absent members and read-only ones are not sent
+	if want := []string{"authors", "description", "title"}; !slices.Equal(sent, want) {
+		t.Errorf("PATCH sent %v, want %v", sent, want)
+	}
+	if patch.description != nil || trimmed(patch.title) != "Emma" {
+		t.Errorf("PATCH title %v, description %v", patch.title, patch.description)
+	}
+	if a := patch.terms["Authors"]; !slices.Equal(a.names, []string{"Jane Austen"}) || !slices.Equal(a.ids, []uint{3}) {
+		t.Errorf("PATCH authors = %+v", a)
+	}
+
+	// PUT replaces: every writable member counts as sent
+	put, err := jsonInput(strings.NewReader(body), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range writable {
+		if !put.sent[name] {
+			t.Errorf("PUT did not send %s", name)
+		}
+	}
+
+	for _, body := range []string{`[]`, `null`, `"title"`, `{`} {
+		if _, err := jsonInput(strings.NewReader(body), false); err == nil {
+			t.Errorf("jsonInput(%s) accepted", body)
+		}
+	}
+	in, err := jsonInput(strings.NewReader(`{"isbn":"x","price":"free","authors":[true]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"isbn", "price", "authors"} {
+		if in.errs[name] == "" {
+			t.Errorf("no error for %s: %v", name, in.errs)
+		}
+	}
+}
+
+func TestPatchMergeRules(t *testing.T) {
+	_, r := newTestHandler(t)
+	b, tag := createBook(t, r, `{"title":"Emma","isbn13":"9780141439587","description":"A novel.",
+		"pageCount":474,"publishedDate":"1815","price":9.5,"stock":3,
+		"authors":["Jane Austen"],"categories":["Fiction"],"publishers":["Penguin"]}`)
+	path := fmt.Sprintf("/books/%d", b.ID)
+	patch := func(body, tag string) *httptest.ResponseRecorder {
+		return serve(r, "PATCH", path, strings.NewReader(body), "Content-Type", "application/merge-patch+json", "If-Match", tag)
+	}
+
+	// null removes, absent keeps, read-only members are ignored
+	w := patch(`{"description":null,"pageCount":null,"authors":null,"price":12,
+		"id":999,"isbn10":"0000000000","coverUrl":"/uploads/x.jpg","version":42}`, tag)
+	if w.Code != 200 {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body)
+	}
+	got := decodeBook(t, w)
+	if got.Description != "" || got.PageCount != nil || len(got.Authors) != 0 {
+		t.Errorf("nulled fields kept: description %q, pageCount %v, authors %v", got.Description, got.PageCount, got.Authors)
+	}
+	if got.Price != 12 {
+		t.Errorf("price = %v, want 12", got.Price)
+	}
+	if got.Title != "Emma" || got.PublishedDate != "1815" || got.Stock != 3 ||
+		got.ISBN13 == nil || *got.ISBN13 != "9780141439587" ||
+		len(got.Categories) != 1 || got.Categories[0].Name != "Fiction" ||
+		len(got.Publishers) != 1 || got.Publishers[0].Name != "Penguin" {
+		t.Errorf("absent fields changed: %+v", got)
+	}
+	if got.ID != b.ID || got.CoverURL != "" || got.Version == 42 ||
+		got.ISBN10 == nil || *got.ISBN10 != "0141439580" {
+		t.Errorf("read-only fields written: id %d, coverUrl %q, version %d, isbn10 %v", got.ID, got.CoverURL, got.Version, got.ISBN10)
+	}
+	tag = w.Header().Get("ETag")
+
+	// a required field can't be removed, and all bad fields are listed
+	w = patch(`{"title":null,"stock":-1,"shelf":"A3"}`, tag)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid PATCH = %d %s, want 400", w.Code, w.Body)
+	}
+	var p api.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"title", "stock", "shelf"} {
+		if p.Fields[f] == "" {
+			t.Errorf("no error for %s: %v", f, p.Fields)
+		}
+	}
+
+	// PATCH only takes JSON
+	w = serve(r, "PATCH", path, strings.NewReader("title=Dune"), "Content-Type", "application/x-www-form-urlencoded", "If-Match", tag)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("form PATCH = %d, want 415", w.Code)
+	}
+
+	// PUT with JSON replaces: what is left out is cleared
+	w = serveJSON(r, "PUT", path, `{"title":"Emma","id":999}`, "If-Match", tag)
+	if w.Code != 200 {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	got = decodeBook(t, w)
+	if got.ID != b.ID || got.ISBN13 != nil || got.Price != 0 || got.Stock != 0 || got.PublishedDate != "" || len(got.Categories) != 0 {
+		t.Errorf("PUT kept fields it left out: %+v", got)
+	}
+}
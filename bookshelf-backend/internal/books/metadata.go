@@ -0,0 +1,274 @@
+package books
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"github.com/giovannyptr/bookshelf/internal/isbn"
+	"github.com/giovannyptr/bookshelf/internal/metadata"
+	"github.com/giovannyptr/bookshelf/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCoverBytes     = 5 << 20
+	maxCoverRedirects = 3
+)
+
+var errCoverTarget = errors.New("cover URL points to a local or private address")
+
+var coverExt = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// LookupResult is the metadata for an ISBN plus the id of the book that
+// already has it, if any.
+type LookupResult struct {
+	metadata.Metadata
+	BookID *uint `json:"bookId" example:"12"`
+}
+
+// lookup resolves the :isbn param with the metadata provider. It answers
+// the request itself when something is wrong.
+func (h *Handler) lookup(c *gin.Context) (*metadata.Metadata, bool) {
+	if h.meta == nil {
+		api.Fail(c, 503, "metadata lookup is disabled")
+		return nil, false
+	}
+	s13, err := isbn.Normalize(c.Param("isbn"))
+	if err != nil {
+		api.Fail(c, 400, err.Error())
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+	m, err := h.meta.Lookup(ctx, s13)
+	if errors.Is(err, metadata.ErrNotFound) {
+		api.Fail(c, 404, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("⚠️  metadata lookup for %s failed: %v", s13, err)
+		api.Fail(c, 502, "metadata lookup failed")
+		return nil, false
+	}
+	return m, true
+}
+
+// lookupISBN godoc
+// @Summary Look up book metadata by ISBN
+// @Description Asks the configured metadata source (Open Library by default) for title, authors, publisher, page count, publication date and cover, to pre-fill a book form. Nothing is stored. bookId is set when a book with this ISBN already exists.
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   isbn path string true "ISBN-10 or ISBN-13"
+// @Success 200 {object} LookupResult
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 502 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router  /books/lookup/{isbn} [get]
+func (h *Handler) LookupISBN(c *gin.Context) {
+	m, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	res := LookupResult{Metadata: *m}
+	if b, err := h.repo.ByISBN(m.ISBN13); err == nil {
+		res.BookID = &b.ID
+	}
+	api.OK(c, res)
+}
+
+// createFromISBN godoc
+// @Summary Create a book from ISBN metadata
+// @Description Looks the ISBN up like GET /books/lookup/{isbn} and stores the result as a new book; unknown authors and publishers are created and the cover is downloaded. Form fields as in POST /books (e.g. price, stock, categories) are optional and win over the looked up values.
+// @Tags    books
+// @Accept  multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param   isbn       path     string   true  "ISBN-10 or ISBN-13"
+// @Param   price      formData number   false "Price"
+// @Param   stock      formData integer  false "Stock"
+// @Param   categories formData []string false "Category names" collectionFormat(multi)
+// @Success 201 {object} models.Book
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 502 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router  /books/isbn/{isbn} [post]
+func (h *Handler) CreateFromISBN(c *gin.Context) {
+	m, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if other, err := h.repo.ByISBN(m.ISBN13); err == nil {
+		api.Fail(c, 409, fmt.Sprintf("book %d already has ISBN %s", other.ID, m.ISBN13))
+		return
+	}
+
+	b := models.Book{Title: strings.TrimSpace(m.Title), PublishedDate: m.PublishedDate, ISBN13: &m.ISBN13}
+	if s10, ok := isbn.To10(m.ISBN13); ok {
+		b.ISBN10 = &s10
+	}
+	if m.PageCount > 0 {
+		b.PageCount = &m.PageCount
+	}
+	// the form may override the title and add price, stock and links
+	in := formInput(c)
+	delete(in.sent, "isbn13")
+	if in.sent["title"] && trimmed(in.title) == "" {
+		delete(in.sent, "title")
+	}
+	if b.Title == "" && !in.sent["title"] {
+		api.Fail(c, 400, "the metadata has no title, send one")
+		return
+	}
+	// the looked up names are created with the book, unless the form
+	// gives its own
+	for assoc, names := range map[string][]string{"Authors": m.Authors, "Publishers": m.Publishers} {
+		if _, ok := in.terms[assoc]; !ok && len(names) > 0 {
+			in.terms[assoc] = termInput{names: names}
+		}
+	}
+	if !h.bind(c, &b, in) {
+		return
+	}
+
+	if m.CoverURL != "" {
+		cover, err := h.downloadCover(c.Request.Context(), m.CoverURL)
+		if err != nil {
+			// a book without cover is still worth creating
+			log.Printf("⚠️  failed to download cover for %s: %v", m.ISBN13, err)
+		}
+		b.CoverURL = cover
+	}
+
+	uid, _ := auth.GetUserID(c)
+	err := h.create(&b, in, uid)
+	if err != nil {
+		h.removeCover(b.CoverURL)
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		api.Fail(c, 409, "a book with this ISBN already exists")
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	c.Header("ETag", etag(&b))
+	api.Created(c, b)
+}
+
+// newCoverClient returns the client covers are downloaded with. The URL
+// comes from the metadata provider, so it only follows http and https, a
+// few redirects, and refuses to connect to loopback, private and
+// link-local addresses. The check runs on the resolved address of every
+// connection, redirects included, so a public name that resolves inward
+// is refused as well.
+func newCoverClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would make the dialed address the proxy's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxCoverRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxCoverRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// publicOnly is a net.Dialer Control hook that refuses addresses inside
+// the network.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	ip := ap.Addr().Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errCoverTarget, ip)
+	}
+	return nil
+}
+
+// downloadCover stores the image at url in the upload dir and returns
+// its public path.
+func (h *Handler) downloadCover(ctx context.Context, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return "", fmt.Errorf("unsupported cover URL scheme %q", req.URL.Scheme)
+	}
+	res, err := h.covers.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", res.Status)
+	}
+	ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	ext, ok := coverExt[ct]
+	if !ok {
+		return "", fmt.Errorf("unsupported cover type %q", ct)
+	}
+	if res.ContentLength > maxCoverBytes {
+		return "", fmt.Errorf("cover is larger than %d bytes", maxCoverBytes)
+	}
+
+	filename := uuid.New().String() + ext
+	dst := filepath.Join(h.uploadDir, filename)
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(res.Body, maxCoverBytes+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > maxCoverBytes {
+		err = fmt.Errorf("cover is larger than %d bytes", maxCoverBytes)
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return "", err
+	}
+	return "/uploads/" + filename, nil
+}
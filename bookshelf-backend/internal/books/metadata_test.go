@@ -0,0 +1,135 @@
+package books
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/internal/metadata"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+type stubProvider struct{ m metadata.Metadata }
+
+func (p stubProvider) Lookup(_ context.Context, isbn13 string) (*metadata.Metadata, error) {
+	m := p.m
+	m.ISBN13 = isbn13
+	return &m, nil
+}
+
+func TestCreateFromISBNLeavesNothingBehind(t *testing.T) {
+	h, r := newTestHandler(t)
+	const s13 = "9780441013593"
+	// the cover download is where another request takes the ISBN
+	var race func()
+	covers := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if race != nil {
+			race()
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer covers.Close()
+	// the test server listens on loopback, which the cover client refuses
+	h.covers = covers.Client()
+	h.meta = stubProvider{metadata.Metadata{Title: "Dune", Authors: []string{"Frank Herbert"}, Publishers: []string{"Chilton"}, CoverURL: covers.URL}}
+	form := func(body string) *httptest.ResponseRecorder {
+		return serve(r, "POST", "/books/isbn/"+s13, strings.NewReader(body), "Content-Type", "application/x-www-form-urlencoded")
+	}
+	nothingLeft := func(step string) {
+		t.Helper()
+		if _, err := h.terms.Authors.ByName("Frank Herbert"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s: author created: %v", step, err)
+		}
+		if _, err := h.terms.Publishers.ByName("Chilton"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s: publisher created: %v", step, err)
+		}
+		if files := uploads(t, h); len(files) != 0 {
+			t.Errorf("%s: cover kept: %v", step, files)
+		}
+	}
+
+	if w := form("price=-1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid form = %d %s, want 400", w.Code, w.Body)
+	}
+	nothingLeft("invalid form")
+
+	race = func() {
+		s := s13
+		if err := h.repo.Create(&models.Book{Title: "Dune (other)", ISBN13: &s}, 0); err != nil {
+			t.Error(err)
+		}
+	}
+	if w := form("price=10"); w.Code != http.StatusConflict {
+		t.Fatalf("create after the ISBN was taken = %d %s, want 409", w.Code, w.Body)
+	}
+	nothingLeft("conflict")
+}
+
+func TestPublicOnly(t *testing.T) {
+	for address, ok := range map[string]bool{
+		"93.184.216.34:443":         true,
+		"[2606:4700::6810:85e5]:80": true,
+		"127.0.0.1:80":              false,
+		"10.0.0.8:80":               false,
+		"172.16.4.1:80":             false,
+		"192.168.1.1:80":            false,
+		"169.254.169.254:80":        false,
+		"0.0.0.0:80":                false,
+		"[::1]:80":                  false,
+		"[fe80::1]:80":              false,
+		"[fd00::1]:80":              false,
+		"[::ffff:127.0.0.1]:80":     false,
+	} {
+		err := publicOnly("tcp", address, nil)
+		if ok && err != nil {
+			t.Errorf("%s refused: %v", address, err)
+		}
+		if !ok && !errors.Is(err, errCoverTarget) {
+			t.Errorf("%s: err = %v, want errCoverTarget", address, err)
+		}
+	}
+}
+
+func TestDownloadCoverRefusesLocalTargets(t *testing.T) {
+	hits := 0
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer local.Close()
+	h := &Handler{uploadDir: t.TempDir(), covers: newCoverClient()}
+
+	if _, err := h.downloadCover(context.Background(), local.URL); !errors.Is(err, errCoverTarget) {
+		t.Errorf("loopback: err = %v, want errCoverTarget", err)
+	}
+	if _, err := h.downloadCover(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("file URL downloaded")
+	}
+	if hits != 0 {
+		t.Errorf("local server got %d requests", hits)
+	}
+}
+
+func TestCoverClientRedirects(t *testing.T) {
+	check := newCoverClient().CheckRedirect
+	req := func(url string) *http.Request { return httptest.NewRequest("GET", url, nil) }
+	via := []*http.Request{req("https://covers.test/1")}
+	if err := check(req("https://covers.test/2"), via); err != nil {
+		t.Errorf("https redirect refused: %v", err)
+	}
+	if err := check(req("ftp://covers.test/2"), via); err == nil {
+		t.Error("redirect to ftp followed")
+	}
+	for len(via) < maxCoverRedirects {
+		via = append(via, req("https://covers.test/n"))
+	}
+	if err := check(req("https://covers.test/last"), via); err == nil {
+		t.Errorf("redirect %d followed", maxCoverRedirects+1)
+	}
+}
@@ -0,0 +1,109 @@
+package books
+
+import (
+	"errors"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm/clause"
+)
+
+// ErrBadCursor is returned by List for a cursor whose sort value doesn't
+// fit its sort field.
+var ErrBadCursor = errors.New("invalid cursor")
+
+// Cursor is a keyset position: the sort value and id of a book. With Back
+// set, List returns the books before it instead of after it.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value any    `json:"v"`
+	ID    uint   `json:"i"`
+	Back  bool   `json:"b,omitempty"`
+}
+
+// Page says which books List returns: those after Cursor if it is set,
+// else Limit books from Offset.
+type Page struct {
+	Sort   string
+	Order  string
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Count  bool // compute Result.Total
+}
+
+type Result struct {
+	Items []models.Book
+	Total *int64
+	Next  *Cursor
+	Prev  *Cursor
+}
+
+// zeroTime stands in for a NULL created_at, matching what GORM reads it as.
+const zeroTime = "0001-01-01 00:00:00+00"
+
+// sortKey is the SQL expression books are ordered by for sort. NULLs are
+// folded into the values Go reads them as, so a cursor built from a book
+// finds that book again.
+func sortKey(sort, query string) clause.Expr {
+	switch sort {
+	case "title":
+		return clause.Expr{SQL: "books.title"}
+	case "price":
+		return clause.Expr{SQL: "coalesce(books.price, 0)"}
+	case "relevance":
+		// ts_rank_cd returns real; cursor values are cast back to it
+		return clause.Expr{SQL: "ts_rank_cd(books.search, " + tsquery + ")", Vars: []any{query}}
+	default:
+		return clause.Expr{SQL: "coalesce(books.created_at, '" + zeroTime + "')"}
+	}
+}
+
+// cursorValue converts a decoded cursor value back to the type its sort
+// field compares with.
+func cursorValue(sort string, v any) (any, string, error) {
+	switch sort {
+	case "title":
+		if s, ok := v.(string); ok {
+			return s, "?", nil
+		}
+	case "price":
+		if f, ok := v.(float64); ok {
+			return f, "?", nil
+		}
+	case "relevance":
+		if f, ok := v.(float64); ok {
+			return f, "?::real", nil
+		}
+	default:
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, "?", nil
+			}
+		}
+	}
+	return nil, "", ErrBadCursor
+}
+
+// cursorFor returns the cursor pointing at b in the given order.
+func (r *Repository) cursorFor(b models.Book, sort, order, query string, back bool) (*Cursor, error) {
+	c := &Cursor{Sort: sort, Order: order, ID: b.ID, Back: back}
+	switch sort {
+	case "title":
+		c.Value = b.Title
+	case "price":
+		c.Value = b.Price
+	case "relevance":
+		var rank float32
+		err := r.db.Model(&models.Book{}).Select("ts_rank_cd(books.search, "+tsquery+")", query).
+			Where("books.id = ?", b.ID).Scan(&rank).Error
+		if err != nil {
+			return nil, err
+		}
+		c.Value = float64(rank)
+	default:
+		c.Value = b.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return c, nil
+}
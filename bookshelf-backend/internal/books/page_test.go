@@ -0,0 +1,236 @@
+package books
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/internal/cursor"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestCursorValue(t *testing.T) {
+	signer := cursor.NewSigner([]byte("test"))
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.UTC)
+	rank := float32(0.1) // not exact in float64
+	tests := []struct {
+		sort        string
+		value       any // as cursorFor sets it
+		want        any
+		placeholder string
+	}{
+		{"title", "Dune", "Dune", "?"},
+		{"price", 60000.5, 60000.5, "?"},
+		{"relevance", float64(rank), float64(rank), "?::real"},
+		{"created_at", created.Format(time.RFC3339Nano), created, "?"},
+		{"created_at", time.Time{}.Format(time.RFC3339Nano), time.Time{}, "?"}, // NULL created_at
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.sort, tt.value), func(t *testing.T) {
+			tok, err := signer.Encode(Cursor{Sort: tt.sort, Order: "DESC", Value: tt.value, ID: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c Cursor
+			if err := signer.Decode(tok, &c); err != nil {
+				t.Fatal(err)
+			}
+			v, placeholder, err := cursorValue(c.Sort, c.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tm, ok := v.(time.Time); ok {
+				if !tm.Equal(tt.want.(time.Time)) {
+					t.Errorf("value = %v, want %v", tm, tt.want)
+				}
+			} else if v != tt.want {
+				t.Errorf("value = %#v, want %#v", v, tt.want)
+			}
+			if placeholder != tt.placeholder {
+				t.Errorf("placeholder = %q, want %q", placeholder, tt.placeholder)
+			}
+			if f, ok := v.(float64); ok && tt.sort == "relevance" && float32(f) != rank {
+				t.Errorf("rank %v does not cast back to %v", f, rank)
+			}
+		})
+	}
+}
+
+func TestCursorValueRejects(t *testing.T) {
+	for _, tt := range []struct {
+		sort  string
+		value any
+	}{
+		{"title", 1.0},
+		{"title", nil},
+		{"price", "60000"},
+		{"relevance", "0.1"},
+		{"created_at", "yesterday"},
+		{"created_at", 1.0},
+	} {
+		if _, _, err := cursorValue(tt.sort, tt.value); !errors.Is(err, ErrBadCursor) {
+			t.Errorf("cursorValue(%q, %#v) err = %v, want ErrBadCursor", tt.sort, tt.value, err)
+		}
+	}
+}
+
+// walk lists all pages of f by following next cursors, checking that each
+// page's prev cursor leads back to the page before it. It returns the ids
+// in order.
+func walk(t *testing.T, r *Repository, f Filter, p Page) []uint {
+	t.Helper()
+	var ids, prevPage []uint
+	for range 100 {
+		res, err := r.List(f, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := bookIDs(res.Items)
+		if prevPage != nil {
+			if res.Prev == nil {
+				t.Fatalf("no prev cursor after %v", prevPage)
+			}
+			back, err := r.List(f, Page{Limit: p.Limit, Cursor: res.Prev})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bookIDs(back.Items); !slices.Equal(got, prevPage) {
+				t.Fatalf("prev of %v = %v, want %v", page, got, prevPage)
+			}
+		}
+		ids = append(ids, page...)
+		if res.Next == nil {
+			return ids
+		}
+		prevPage, p.Cursor = page, res.Next
+	}
+	t.Fatal("next cursors never end")
+	return nil
+}
+
+func bookIDs(books []models.Book) []uint {
+	ids := make([]uint, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+	return ids
+}
+
+func TestListCursors(t *testing.T) {
+	h, _ := newTestHandler(t)
+	r := h.repo
+	var all []uint
+	for i, title := range []string{"Dune", "Emma", "Ulysses", "Beloved", "Hamlet", "Dracula", "Middlemarch"} {
+		desc := "a novel"
+		if i%2 == 0 {
+			desc = "a novel, a classic novel"
+		}
+		b := models.Book{Title: title, Description: desc, Price: float64(i%3) * 10}
+		if err := r.Create(&b, 0); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, b.ID)
+	}
+	// a book without created_at sorts as the zero time
+	if err := r.db.Model(&models.Book{}).Where("id = ?", all[2]).Update("created_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		sort, order, query string
+	}{
+		{"title", "ASC", ""},
+		{"title", "DESC", ""},
+		{"price", "ASC", ""},
+		{"price", "DESC", ""},
+		{"created_at", "ASC", ""},
+		{"created_at", "DESC", ""},
+		{"relevance", "DESC", "novel"},
+		{"relevance", "ASC", "novel"},
+	} {
+		t.Run(tt.sort+" "+tt.order, func(t *testing.T) {
+			f := Filter{Query: tt.query}
+			full, err := r.List(f, Page{Sort: tt.sort, Order: tt.order, Limit: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bookIDs(full.Items)
+			if len(want) != len(all) {
+				t.Fatalf("listed %d books, want %d", len(want), len(all))
+			}
+			if got := walk(t, r, f, Page{Sort: tt.sort, Order: tt.order, Limit: 2}); !slices.Equal(got, want) {
+				t.Errorf("walked %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestListCursorsStableUnderInserts(t *testing.T) {
+	h, _ := newTestHandler(t)
+	r := h.repo
+	for _, title := range []string{"Dune", "Emma", "Ulysses", "Beloved", "Hamlet"} {
+		if err := r.Create(&models.Book{Title: title}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := r.List(Filter{}, Page{Sort: "created_at", Order: "DESC", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.List(Filter{}, Page{Limit: 2, Cursor: first.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newer books land on the first page; offsets would shift by them
+	for _, title := range []string{"Middlemarch", "Dracula"} {
+		if err := r.Create(&models.Book{Title: title}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	again, err := r.List(Filter{}, Page{Limit: 2, Cursor: first.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bookIDs(again.Items), bookIDs(second.Items); !slices.Equal(got, want) {
+		t.Errorf("next page after inserts = %v, want %v", got, want)
+	}
+	back, err := r.List(Filter{}, Page{Limit: 2, Cursor: second.Prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bookIDs(back.Items), bookIDs(first.Items); !slices.Equal(got, want) {
+		t.Errorf("prev page after inserts = %v, want %v", got, want)
+	}
+	// the first page now has books before it
+	if back.Prev == nil {
+		t.Error("no prev cursor before the inserted books")
+	}
+}
+
+func TestListRejectsBadCursors(t *testing.T) {
+	h, r := newTestHandler(t)
+	good, err := h.cursors.Encode(Cursor{Sort: "title", Order: "ASC", Value: "Dune", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := cursor.NewSigner([]byte("other")).Encode(Cursor{Sort: "title", Order: "ASC", Value: "Dune", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed by us, but the value doesn't fit the sort
+	mistyped, err := h.cursors.Encode(Cursor{Sort: "created_at", Order: "ASC", Value: "Dune", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "GET", "/books?cursor="+good, nil); w.Code != 200 {
+		t.Fatalf("good cursor = %d %s", w.Code, w.Body)
+	}
+	for name, tok := range map[string]string{"tampered": "A" + good, "foreign": foreign, "mistyped": mistyped} {
+		if w := serve(r, "GET", "/books?cursor="+tok, nil); w.Code != 400 {
+			t.Errorf("%s cursor = %d %s, want 400", name, w.Code, w.Body)
+		}
+	}
+}
@@ -1,49 +1,506 @@
 package books
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/giovannyptr/bookshelf/internal/catalog"
 	"github.com/giovannyptr/bookshelf/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrStale means the book was changed since the version being saved was
+// read.
+var ErrStale = errors.New("book was changed by someone else")
+
 type Repository struct{ db *gorm.DB }
 
 func NewRepository(db *gorm.DB) *Repository { return &Repository{db: db} }
 
-func (r *Repository) Migrate() error { return r.db.AutoMigrate(&models.Book{}) }
+// WithDB returns a Repository using db, e.g. a transaction.
+func (r *Repository) WithDB(db *gorm.DB) *Repository { return &Repository{db: db} }
+
+// Transaction runs fn in a transaction; nested calls use savepoints.
+func (r *Repository) Transaction(fn func(tx *gorm.DB) error) error { return r.db.Transaction(fn) }
+
+// withLinks preloads the authors, categories and publishers of books.
+func withLinks(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Authors", orderByName).Preload("Categories", orderByName).Preload("Publishers", orderByName)
+}
+
+func orderByName(tx *gorm.DB) *gorm.DB { return tx.Order("lower(name)") }
+
+// linkedTo matches books linked to any of the terms of kind, each given
+// by id or, case insensitively, by name.
+func linkedTo(kind catalog.Kind, terms []string) (string, []any) {
+	var ids []uint64
+	var names []string
+	for _, t := range terms {
+		if id, err := strconv.ParseUint(t, 10, 64); err == nil {
+			ids = append(ids, id)
+		} else {
+			names = append(names, strings.ToLower(t))
+		}
+	}
+	var conds []string
+	var args []any
+	if len(ids) > 0 {
+		conds, args = append(conds, "j."+kind.JoinKey+" IN ?"), append(args, ids)
+	}
+	if len(names) > 0 {
+		conds, args = append(conds, "lower(t.name) IN ?"), append(args, names)
+	}
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM %s j JOIN %s t ON t.id = j.%s WHERE j.book_id = books.id AND (%s))",
+		kind.JoinTable, kind.Table, kind.JoinKey, strings.Join(conds, " OR ")), args
+}
+
+// searchConfig is the text search configuration books.search is built
+// with (migration 0006); queries must use the same one.
+const searchConfig = "english"
+
+// tsquery parses a user query with web search syntax: quoted phrases, OR,
+// and -word to exclude.
+const tsquery = "websearch_to_tsquery('" + searchConfig + "', ?)"
 
-func (r *Repository) List(q, category string, page, limit int, sort, order string) (items []models.Book, total int64, err error) {
+// Filter narrows List. Within Categories, Authors and Publishers (ids or
+// names) a book matches any value; all the filters given must match.
+type Filter struct {
+	Query       string // full-text search over title, authors and description
+	Categories  []string
+	Authors     []string
+	Publishers  []string
+	MinPrice    *float64
+	MaxPrice    *float64
+	InStock     bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time // exclusive
+}
+
+// facetPrice names the price filter for filtered's except argument; the
+// link filters go by their catalog.Kind table.
+const facetPrice = "price"
+
+// filtered returns the books matching f, leaving out the filter named by
+// except so a facet can count the values that filter would offer.
+func (r *Repository) filtered(f Filter, except string) *gorm.DB {
 	tx := r.db.Model(&models.Book{})
-	if q != "" {
-		like := "%" + q + "%"
-		tx = tx.Where("title ILIKE ? OR author ILIKE ?", like, like)
+	if f.Query != "" {
+		tx = tx.Where("books.search @@ "+tsquery, f.Query)
+	}
+	for _, l := range []struct {
+		kind  catalog.Kind
+		terms []string
+	}{{catalog.Categories, f.Categories}, {catalog.Authors, f.Authors}, {catalog.Publishers, f.Publishers}} {
+		if len(l.terms) > 0 && except != l.kind.Table {
+			q, args := linkedTo(l.kind, l.terms)
+			tx = tx.Where(q, args...)
+		}
+	}
+	if except != facetPrice {
+		if f.MinPrice != nil {
+			tx = tx.Where("books.price >= ?", *f.MinPrice)
+		}
+		if f.MaxPrice != nil {
+			tx = tx.Where("books.price <= ?", *f.MaxPrice)
+		}
+	}
+	if f.InStock {
+		tx = tx.Where("books.stock > 0")
 	}
-	if category != "" {
-		tx = tx.Where("category = ?", category)
+	if f.CreatedFrom != nil {
+		tx = tx.Where("books.created_at >= ?", *f.CreatedFrom)
 	}
-	_ = tx.Count(&total).Error
+	if f.CreatedTo != nil {
+		tx = tx.Where("books.created_at < ?", *f.CreatedTo)
+	}
+	return tx
+}
 
-	allowed := map[string]bool{"title": true, "category": true, "price": true, "created_at": true}
-	if !allowed[sort] {
+// List returns a page of the books matching f, with cursors to the pages
+// before and after it. Books are ordered by p.Sort, then id.
+func (r *Repository) List(f Filter, p Page) (res Result, err error) {
+	sort, order := p.Sort, p.Order
+	if p.Cursor != nil {
+		sort, order = p.Cursor.Sort, p.Cursor.Order
+	}
+	allowed := map[string]bool{"title": true, "price": true, "created_at": true, "relevance": true}
+	if !allowed[sort] || (sort == "relevance" && f.Query == "") {
 		sort = "created_at"
 	}
 	if order != "ASC" {
 		order = "DESC"
 	}
 
-	err = tx.Order(fmt.Sprintf("%s %s", sort, order)).
-		Offset((page - 1) * limit).Limit(limit).
-		Find(&items).Error
+	tx := r.filtered(f, "")
+	if p.Count {
+		var total int64
+		if err = tx.Count(&total).Error; err != nil {
+			return
+		}
+		res.Total = &total
+	}
+
+	// walking back reads the preceding rows in reverse, then flips them
+	back := p.Cursor != nil && p.Cursor.Back
+	dir := order
+	if back {
+		dir = map[string]string{"ASC": "DESC", "DESC": "ASC"}[order]
+	}
+	key := sortKey(sort, f.Query)
+	if p.Cursor != nil {
+		v, placeholder, err := cursorValue(sort, p.Cursor.Value)
+		if err != nil {
+			return res, err
+		}
+		cmp := ">"
+		if dir == "DESC" {
+			cmp = "<"
+		}
+		tx = tx.Where(clause.Expr{
+			SQL:  "(" + key.SQL + ", books.id) " + cmp + " (" + placeholder + ", ?)",
+			Vars: append(append([]any{}, key.Vars...), v, p.Cursor.ID),
+		})
+	} else {
+		tx = tx.Offset(p.Offset)
+	}
+	tx = tx.Order(clause.OrderBy{Expression: clause.Expr{
+		SQL:                key.SQL + " " + dir + ", books.id " + dir,
+		Vars:               key.Vars,
+		WithoutParentheses: true,
+	}})
+
+	// one extra row tells whether there is another page
+	if err = withLinks(tx).Limit(p.Limit + 1).Find(&res.Items).Error; err != nil {
+		return
+	}
+	more := len(res.Items) > p.Limit
+	if more {
+		res.Items = res.Items[:p.Limit]
+	}
+	if back {
+		slices.Reverse(res.Items)
+	}
+	if n := len(res.Items); n > 0 {
+		hasNext, hasPrev := more, p.Cursor != nil || p.Offset > 0
+		if back {
+			hasNext, hasPrev = true, more
+		}
+		if hasNext {
+			if res.Next, err = r.cursorFor(res.Items[n-1], sort, order, f.Query, false); err != nil {
+				return
+			}
+		}
+		if hasPrev {
+			if res.Prev, err = r.cursorFor(res.Items[0], sort, order, f.Query, true); err != nil {
+				return
+			}
+		}
+	}
+
+	if f.Query != "" {
+		err = r.highlight(res.Items, f.Query)
+	}
+	return
+}
+
+// highlight sets the search snippets for query on books. The snippets are
+// not HTML-escaped; only the <b></b> around matches is markup.
+func (r *Repository) highlight(books []models.Book, query string) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+	var rows []struct {
+		ID uint
+		models.BookHighlight
+	}
+	err := r.db.Raw(`SELECT b.id,
+			ts_headline('`+searchConfig+`', b.title, q, 'HighlightAll=true') AS title,
+			ts_headline('`+searchConfig+`', book_author_names(b.id), q, 'HighlightAll=true') AS authors,
+			ts_headline('`+searchConfig+`', b.description, q, 'MaxFragments=2, MinWords=5, MaxWords=25') AS description
+		FROM books b, `+tsquery+` q
+		WHERE b.id IN ?`, query, ids).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	byID := make(map[uint]*models.BookHighlight, len(rows))
+	for i := range rows {
+		byID[rows[i].ID] = &rows[i].BookHighlight
+	}
+	for i := range books {
+		books[i].Highlight = byID[books[i].ID]
+	}
+	return nil
+}
+
+// Export calls fn with the books matching f, batch at a time in id order,
+// so the whole catalog is never held in memory.
+func (r *Repository) Export(f Filter, batch int, fn func([]models.Book) error) error {
+	var books []models.Book
+	return withLinks(r.filtered(f, "")).FindInBatches(&books, batch, func(_ *gorm.DB, _ int) error {
+		return fn(books)
+	}).Error
+}
+
+func (r *Repository) ByID(id uint) (models.Book, error) {
+	var b models.Book
+	err := withLinks(r.db).First(&b, id).Error
+	return b, err
+}
+
+// ByISBN looks a book up by its normalized ISBN-13.
+func (r *Repository) ByISBN(isbn13 string) (models.Book, error) {
+	var b models.Book
+	err := withLinks(r.db).Where("isbn13 = ?", isbn13).First(&b).Error
+	return b, err
+}
+
+// Create inserts b and links it to its (already stored) authors,
+// categories and publishers, recording userID (0 if unknown) as its
+// creator.
+func (r *Repository) Create(b *models.Book, userID uint) error {
+	b.Version = 1
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Authors.*", "Categories.*", "Publishers.*").Create(b).Error; err != nil {
+			return err
+		}
+		return record(tx, b.ID, models.RevisionCreate, userID, nil, b)
+	})
+}
+
+// Save updates the columns of b and, for each association named in
+// links ("Authors", "Categories", "Publishers"), replaces the linked rows
+// with the ones on b. The change is recorded as a revision by userID.
+// It returns ErrStale unless b.Version is the stored one, and bumps it.
+func (r *Repository) Save(b *models.Book, userID uint, links ...string) error {
+	return r.save(b, models.RevisionUpdate, userID, links)
+}
+
+// Revert saves b, set from an earlier revision, with all its links.
+func (r *Repository) Revert(b *models.Book, userID uint) error {
+	return r.save(b, models.RevisionRevert, userID, []string{"Authors", "Categories", "Publishers"})
+}
+
+func (r *Repository) save(b *models.Book, action string, userID uint, links []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockBook(tx, b.ID)
+		if err != nil {
+			return err
+		}
+		if before.Version != b.Version {
+			return ErrStale
+		}
+		b.Version++
+		if err := tx.Omit(clause.Associations).Save(b).Error; err != nil {
+			return err
+		}
+		for _, name := range links {
+			var values any
+			switch name {
+			case "Authors":
+				values = b.Authors
+			case "Categories":
+				values = b.Categories
+			case "Publishers":
+				values = b.Publishers
+			default:
+				return fmt.Errorf("unknown book association %q", name)
+			}
+			if err := tx.Model(b).Omit(name + ".*").Association(name).Replace(values); err != nil {
+				return err
+			}
+		}
+		var after models.Book
+		if err := withLinks(tx).Where("id = ?", b.ID).First(&after).Error; err != nil {
+			return err
+		}
+		return record(tx, b.ID, action, userID, &before, &after)
+	})
+}
+
+// RecordChange runs change, which alters the books with the given ids
+// through something they link to, such as a renamed author, and records
+// it as a revision of each by userID. Books in the trash get none. It
+// runs in tx, the caller's transaction, and locks the books in id order.
+func (r *Repository) RecordChange(tx *gorm.DB, ids []uint, userID uint, change func() error) error {
+	var before []models.Book
+	if len(ids) > 0 {
+		var locked []models.Book
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id IN ?", ids).Order("id").Find(&locked).Error
+		if err != nil {
+			return err
+		}
+		if err := withLinks(tx).Where("id IN ?", ids).Order("id").Find(&before).Error; err != nil {
+			return err
+		}
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	for i := range before {
+		var after models.Book
+		if err := withLinks(tx).Where("id = ?", before[i].ID).First(&after).Error; err != nil {
+			return err
+		}
+		if err := record(tx, after.ID, models.RevisionUpdate, userID, &before[i], &after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete moves b to the trash; Purge removes it for good. Like Save it
+// returns ErrStale if b is not the stored version.
+func (r *Repository) Delete(b *models.Book, userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockBook(tx, b.ID)
+		if err != nil {
+			return err
+		}
+		if before.Version != b.Version {
+			return ErrStale
+		}
+		if err := tx.Delete(b).Error; err != nil {
+			return err
+		}
+		return record(tx, b.ID, models.RevisionDelete, userID, &before, nil)
+	})
+}
+
+// lockBook reads book id with its links and holds its row until the
+// transaction ends, so revisions of one book are numbered in turn.
+func lockBook(tx *gorm.DB, id uint) (models.Book, error) {
+	var b models.Book
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", id).Take(&b).Error
+	if err != nil {
+		return b, err
+	}
+	err = withLinks(tx).Where("id = ?", id).First(&b).Error
+	return b, err
+}
+
+// Trash returns a page of the deleted books, most recently deleted first.
+func (r *Repository) Trash(page, limit int) (items []models.Book, total int64, err error) {
+	tx := r.db.Unscoped().Model(&models.Book{}).Where("books.deleted_at IS NOT NULL")
+	if err = tx.Count(&total).Error; err != nil {
+		return
+	}
+	err = withLinks(tx).Order("books.deleted_at DESC, books.id DESC").
+		Offset((page - 1) * limit).Limit(limit).Find(&items).Error
 	return
 }
 
-func (r *Repository) ByID(id string) (models.Book, error) {
+// Restore takes book id out of the trash. It returns
+// gorm.ErrRecordNotFound if the book is not in the trash and
+// gorm.ErrDuplicatedKey if another book has taken its ISBN meanwhile.
+func (r *Repository) Restore(id uint, userID uint) (models.Book, error) {
 	var b models.Book
-	err := r.db.First(&b, id).Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).First(&b).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(&b).
+			Updates(map[string]any{"deleted_at": nil, "version": gorm.Expr("version + 1")}).Error
+		if err != nil {
+			return err
+		}
+		if err := withLinks(tx).Where("id = ?", id).First(&b).Error; err != nil {
+			return err
+		}
+		return record(tx, id, models.RevisionRestore, userID, nil, &b)
+	})
 	return b, err
 }
 
-func (r *Repository) Create(b *models.Book) error { return r.db.Create(b).Error }
-func (r *Repository) Save(b *models.Book) error   { return r.db.Save(b).Error }
-func (r *Repository) Delete(b *models.Book) error { return r.db.Delete(b).Error }
+// Purge permanently removes up to limit books deleted before the given
+// time, with their links and revisions. It returns how many went and the
+// cover URLs they and their revisions used, so the files can go too.
+func (r *Repository) Purge(before time.Time, limit int) (n int, covers []string, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Unscoped().Model(&models.Book{}).
+			Where("deleted_at < ?", before).
+			Order("deleted_at").Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		err = tx.Raw(`SELECT DISTINCT c FROM (
+				SELECT cover_url AS c FROM books WHERE id IN ?
+				UNION ALL SELECT before->>'coverUrl' FROM book_revisions WHERE book_id IN ?
+				UNION ALL SELECT after->>'coverUrl' FROM book_revisions WHERE book_id IN ?
+			) covers WHERE c <> ''`, ids, ids, ids).Scan(&covers).Error
+		if err != nil {
+			return err
+		}
+		n = len(ids)
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Book{}).Error
+	})
+	return
+}
+
+// CoversInUse returns which of urls a book, deleted or not, has as its
+// cover, or a revision made since the given time had.
+func (r *Repository) CoversInUse(urls []string, since time.Time) (map[string]bool, error) {
+	var used []string
+	err := r.db.Raw(`
+		SELECT cover_url FROM books WHERE cover_url IN ?
+		UNION SELECT before->>'coverUrl' FROM book_revisions WHERE created_at >= ? AND before->>'coverUrl' IN ?
+		UNION SELECT after->>'coverUrl' FROM book_revisions WHERE created_at >= ? AND after->>'coverUrl' IN ?`,
+		urls, since, urls, since, urls).Scan(&used).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(used))
+	for _, u := range used {
+		out[u] = true
+	}
+	return out, nil
+}
+
+func (r *Repository) CreateImportReport(rep *models.ImportReport) error {
+	return r.db.Create(rep).Error
+}
+
+func (r *Repository) ImportReport(id uint) (models.ImportReport, error) {
+	var rep models.ImportReport
+	err := r.db.Where("id = ?", id).First(&rep).Error
+	return rep, err
+}
+
+// History returns a page of the revisions of book id, newest first, with
+// the name of the user who made each.
+func (r *Repository) History(id uint, page, limit int) (items []models.BookRevision, total int64, err error) {
+	tx := r.db.Model(&models.BookRevision{}).Where("book_revisions.book_id = ?", id)
+	if err = tx.Count(&total).Error; err != nil {
+		return
+	}
+	err = withUserName(tx).Order("book_revisions.revision DESC").
+		Offset((page - 1) * limit).Limit(limit).Find(&items).Error
+	return
+}
+
+// Revision returns revision n of book id.
+func (r *Repository) Revision(id uint, n int) (models.BookRevision, error) {
+	var rev models.BookRevision
+	err := withUserName(r.db.Model(&models.BookRevision{})).
+		Where("book_revisions.book_id = ? AND book_revisions.revision = ?", id, n).
+		First(&rev).Error
+	return rev, err
+}
+
+func withUserName(tx *gorm.DB) *gorm.DB {
+	return tx.Select("book_revisions.*, coalesce(users.name, '') AS user_name").
+		Joins("LEFT JOIN users ON users.id = book_revisions.user_id")
+}
@@ -0,0 +1,63 @@
+package books
+
+import (
+	"github.com/giovannyptr/bookshelf/internal/catalog"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+// Terms resolves the authors, categories and publishers named in book
+// payloads.
+type Terms struct {
+	Authors    *catalog.Repository
+	Categories *catalog.Repository
+	Publishers *catalog.Repository
+}
+
+// termField maps the form fields of one association. Each can be given
+// by id (repeated or comma separated) or by name (repeated; unknown names
+// are created); names is also the JSON member. legacy is the single
+// free-text field from before the association existed.
+type termField struct {
+	ids, names, legacy string
+	assoc              string
+	singular           string
+	repo               func(Terms) *catalog.Repository
+}
+
+var termFields = []termField{
+	{"authorIds", "authors", "author", "Authors", "author", func(t Terms) *catalog.Repository { return t.Authors }},
+	{"categoryIds", "categories", "category", "Categories", "category", func(t Terms) *catalog.Repository { return t.Categories }},
+	{"publisherIds", "publishers", "publisher", "Publishers", "publisher", func(t Terms) *catalog.Repository { return t.Publishers }},
+}
+
+// setTerms replaces the association assoc ("Authors", "Categories" or
+// "Publishers") of b with terms.
+func setTerms(b *models.Book, assoc string, terms []models.Term) {
+	switch assoc {
+	case "Authors":
+		b.Authors = make([]models.Author, len(terms))
+		for i, t := range terms {
+			b.Authors[i] = models.Author{Term: t}
+		}
+	case "Categories":
+		b.Categories = make([]models.Category, len(terms))
+		for i, t := range terms {
+			b.Categories[i] = models.Category{Term: t}
+		}
+	case "Publishers":
+		b.Publishers = make([]models.Publisher, len(terms))
+		for i, t := range terms {
+			b.Publishers[i] = models.Publisher{Term: t}
+		}
+	}
+}
+
+// withDB returns t with every repository using db, e.g. a transaction.
+func (t Terms) withDB(db *gorm.DB) Terms {
+	return Terms{
+		Authors:    t.Authors.WithDB(db),
+		Categories: t.Categories.WithDB(db),
+		Publishers: t.Publishers.WithDB(db),
+	}
+}
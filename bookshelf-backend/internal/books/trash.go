@@ -0,0 +1,193 @@
+package books
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"gorm.io/gorm"
+)
+
+// purgeBatch is how many books one purge transaction removes.
+const purgeBatch = 100
+
+// trash godoc
+// @Summary List deleted books
+// @Description Books stay in the trash until restored or purged after the retention period.
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   page  query int false "Page number" default(1)
+// @Param   limit query int false "Page size (1-100)" default(10)
+// @Success 200 {object} api.PagedTrash
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Router  /books/trash [get]
+func (h *Handler) Trash(c *gin.Context) {
+	page, limit := pageParams(c)
+	items, total, err := h.repo.Trash(page, limit)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	api.OK(c, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// restore godoc
+// @Summary Restore a deleted book
+// @Tags    books
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "Book ID"
+// @Success 200 {object} models.Book
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "another book has its ISBN"
+// @Router  /books/{id}/restore [post]
+func (h *Handler) Restore(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		api.Fail(c, 404, "book not found in the trash")
+		return
+	}
+	uid, _ := auth.GetUserID(c)
+	b, err := h.repo.Restore(id, uid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		api.Fail(c, 404, "book not found in the trash")
+		return
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		api.Fail(c, 409, "another book now has this ISBN")
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	c.Header("ETag", etag(&b))
+	api.OK(c, b)
+}
+
+// PurgeTrash permanently removes the books deleted more than retention
+// ago, with their history and covers, and the covers replaced longer ago
+// than that, then again every interval until ctx is done.
+func (h *Handler) PurgeTrash(ctx context.Context, retention, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		before := time.Now().Add(-retention)
+		n, err := h.purge(before)
+		if err != nil {
+			log.Printf("⚠️  Failed to purge the book trash: %v\n", err)
+		} else if n > 0 {
+			log.Printf("🗑️ Purged %d books from the trash\n", n)
+		}
+		n, err = h.sweepCovers(before)
+		if err != nil {
+			log.Printf("⚠️  Failed to sweep unused covers: %v\n", err)
+		} else if n > 0 {
+			log.Printf("🗑️ Removed %d unused covers\n", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// purge removes the books deleted before the given time, a batch at a
+// time, and returns how many went.
+func (h *Handler) purge(before time.Time) (int, error) {
+	total := 0
+	for {
+		n, covers, err := h.repo.Purge(before, purgeBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, url := range covers {
+			h.removeCover(url)
+		}
+		total += n
+		if n < purgeBatch {
+			return total, nil
+		}
+	}
+}
+
+// sweepCovers removes the uploaded covers older than before that no book
+// has any more and no revision made since refers to: covers replaced on a
+// live book, or saved for a book that then failed to save. Newer files are
+// left alone, as their book may still be being saved. It returns how many
+// went.
+func (h *Handler) sweepCovers(before time.Time) (int, error) {
+	entries, err := os.ReadDir(h.uploadDir)
+	if err != nil {
+		return 0, err
+	}
+	var urls []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(before) {
+			urls = append(urls, "/uploads/"+e.Name())
+		}
+	}
+	removed := 0
+	for batch := range slices.Chunk(urls, purgeBatch) {
+		used, err := h.repo.CoversInUse(batch, before)
+		if err != nil {
+			return removed, err
+		}
+		for _, url := range batch {
+			if !used[url] {
+				h.removeCover(url)
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// coverPath is the file of an uploaded cover. Covers are served from
+// uploadDir as /uploads/<name>; ok is false for any other URL.
+func (h *Handler) coverPath(url string) (path string, ok bool) {
+	name, ok := strings.CutPrefix(url, "/uploads/")
+	if !ok || name == "" || name != filepath.Base(name) {
+		return "", false
+	}
+	return filepath.Join(h.uploadDir, name), true
+}
+
+// removeCover deletes an uploaded cover; any other URL is left alone.
+func (h *Handler) removeCover(url string) {
+	path, ok := h.coverPath(url)
+	if !ok {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("⚠️  Failed to remove cover %s: %v\n", filepath.Base(path), err)
+	}
+}
+
+// coverGone reports whether url is an uploaded cover whose file has been
+// removed.
+func (h *Handler) coverGone(url string) bool {
+	path, ok := h.coverPath(url)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(path)
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,54 @@
+package books
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestSweepCovers(t *testing.T) {
+	h, _ := newTestHandler(t)
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for _, name := range []string{"live.jpg", "replaced.jpg", "orphan.jpg", "fresh.jpg", ".keep"} {
+		path := filepath.Join(h.uploadDir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "fresh.jpg" {
+			os.Chtimes(path, old, old)
+		}
+	}
+	b := models.Book{Title: "Dune", CoverURL: "/uploads/replaced.jpg"}
+	if err := h.repo.Create(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	b.CoverURL = "/uploads/live.jpg"
+	if err := h.repo.Save(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	before := now.Add(-24 * time.Hour)
+	if n, err := h.sweepCovers(before); err != nil || n != 1 {
+		t.Fatalf("sweep = (%d, %v), want 1 removed", n, err)
+	}
+	want := []string{".keep", "fresh.jpg", "live.jpg", "replaced.jpg"}
+	if got := uploads(t, h); !slices.Equal(got, want) {
+		t.Fatalf("after sweep: %v, want %v", got, want)
+	}
+
+	// once the revision that replaced it is older too, the replaced cover goes
+	if err := h.repo.db.Model(&models.BookRevision{}).Where("book_id = ?", b.ID).Update("created_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := h.sweepCovers(before); err != nil || n != 1 {
+		t.Fatalf("second sweep = (%d, %v), want 1 removed", n, err)
+	}
+	if !h.coverGone("/uploads/replaced.jpg") || h.coverGone("/uploads/live.jpg") {
+		t.Errorf("after second sweep: %v", uploads(t, h))
+	}
+}
@@ -0,0 +1,212 @@
+package catalog
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/internal/auth"
+	"gorm.io/gorm"
+)
+
+// Handler serves CRUD for one Kind; routes are mounted under /<Kind.Table>.
+type Handler struct {
+	repo    *Repository
+	kind    Kind
+	history BookHistory
+}
+
+func NewHandler(repo *Repository, history BookHistory) *Handler {
+	return &Handler{repo: repo, kind: repo.kind, history: history}
+}
+
+func (h *Handler) load(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, h.kind.Singular+" not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// list godoc
+// @Summary List authors, categories or publishers
+// @Description Sorted by name; each item carries the number of linked books.
+// @Tags    catalog
+// @Produce json
+// @Param   q     query string false "Search by name"
+// @Param   page  query int    false "Page number"  default(1)
+// @Param   limit query int    false "Page size (1-100)" default(50)
+// @Success 200 {object} api.PagedTerms
+// @Router  /authors [get]
+// @Router  /categories [get]
+// @Router  /publishers [get]
+func (h *Handler) List(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	items, total, err := h.repo.List(q, page, limit)
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	api.OK(c, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// detail godoc
+// @Summary Get an author, category or publisher
+// @Tags    catalog
+// @Produce json
+// @Param   id path int true "ID"
+// @Success 200 {object} models.Term
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /authors/{id} [get]
+// @Router  /categories/{id} [get]
+// @Router  /publishers/{id} [get]
+func (h *Handler) Detail(c *gin.Context) {
+	id, ok := h.load(c)
+	if !ok {
+		return
+	}
+	t, err := h.repo.ByID(id)
+	if err != nil {
+		api.Abort(c, api.NotFound(err, h.kind.Singular+" not found"))
+		return
+	}
+	api.OK(c, t)
+}
+
+type termDTO struct {
+	Name string `json:"name" binding:"required,notblank,max=200" example:"George Orwell"`
+}
+
+func (h *Handler) bindName(c *gin.Context) (string, bool) {
+	var in termDTO
+	if !api.BindJSON(c, &in) {
+		return "", false
+	}
+	return strings.TrimSpace(in.Name), true
+}
+
+// create godoc
+// @Summary Create an author, category or publisher
+// @Description Names are unique regardless of case.
+// @Tags    catalog
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   payload body termDTO true "Name"
+// @Success 201 {object} models.Term
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /authors [post]
+// @Router  /categories [post]
+// @Router  /publishers [post]
+func (h *Handler) Create(c *gin.Context) {
+	name, ok := h.bindName(c)
+	if !ok {
+		return
+	}
+	t, err := h.repo.Create(name)
+	if errors.Is(err, ErrNameTaken) {
+		api.Fail(c, http.StatusConflict, h.kind.Singular+" "+err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	api.Created(c, t)
+}
+
+// update godoc
+// @Summary Rename an author, category or publisher
+// @Description Every linked book shows the new name, recorded as a revision in its history. Use this to merge spelling variants by hand.
+// @Tags    catalog
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   id      path int     true "ID"
+// @Param   payload body termDTO true "New name"
+// @Success 200 {object} models.Term
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /authors/{id} [put]
+// @Router  /categories/{id} [put]
+// @Router  /publishers/{id} [put]
+func (h *Handler) Update(c *gin.Context) {
+	id, ok := h.load(c)
+	if !ok {
+		return
+	}
+	name, ok := h.bindName(c)
+	if !ok {
+		return
+	}
+	t, err := h.repo.ByID(id)
+	if err != nil {
+		api.Abort(c, api.NotFound(err, h.kind.Singular+" not found"))
+		return
+	}
+	uid, _ := auth.GetUserID(c)
+	err = h.repo.Rename(t, name, h.history, uid)
+	if errors.Is(err, ErrNameTaken) {
+		api.Fail(c, http.StatusConflict, h.kind.Singular+" "+err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	api.OK(c, t)
+}
+
+// delete godoc
+// @Summary Delete an author, category or publisher
+// @Description Only possible once no book links to it.
+// @Tags    catalog
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Router  /authors/{id} [delete]
+// @Router  /categories/{id} [delete]
+// @Router  /publishers/{id} [delete]
+func (h *Handler) Delete(c *gin.Context) {
+	id, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if _, err := h.repo.ByID(id); errors.Is(err, gorm.ErrRecordNotFound) {
+		api.Fail(c, http.StatusNotFound, h.kind.Singular+" not found")
+		return
+	}
+	err := h.repo.Delete(id)
+	if errors.Is(err, ErrInUse) {
+		api.Fail(c, http.StatusConflict, h.kind.Singular+" is "+err.Error())
+		return
+	}
+	if err != nil {
+		api.Abort(c, err)
+		return
+	}
+	api.OK(c, gin.H{"message": h.kind.Singular + " deleted"})
+}
@@ -0,0 +1,206 @@
+// Package catalog manages the authors, categories and publishers that
+// books link to. The three share one shape (models.Term) and differ only
+// in their table.
+package catalog
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNameTaken = errors.New("name already exists")
+	ErrInUse     = errors.New("still linked to books (including books in the trash)")
+)
+
+// Kind describes one of the entities.
+type Kind struct {
+	Table     string // e.g. "authors"
+	JoinTable string // e.g. "book_authors"
+	JoinKey   string // e.g. "author_id"
+	Singular  string // e.g. "author", used in messages
+}
+
+var (
+	Authors    = Kind{Table: "authors", JoinTable: "book_authors", JoinKey: "author_id", Singular: "author"}
+	Categories = Kind{Table: "categories", JoinTable: "book_categories", JoinKey: "category_id", Singular: "category"}
+	Publishers = Kind{Table: "publishers", JoinTable: "book_publishers", JoinKey: "publisher_id", Singular: "publisher"}
+)
+
+type Repository struct {
+	db   *gorm.DB
+	kind Kind
+}
+
+func NewRepository(db *gorm.DB, kind Kind) *Repository { return &Repository{db: db, kind: kind} }
+
+// WithDB returns a Repository for the same kind using db, e.g. a
+// transaction.
+func (r *Repository) WithDB(db *gorm.DB) *Repository { return &Repository{db: db, kind: r.kind} }
+
+// TermWithCount is a term plus the number of books linked to it.
+type TermWithCount struct {
+	models.Term
+	Books int64 `json:"books" example:"3"`
+}
+
+func (r *Repository) List(q string, page, limit int) (items []TermWithCount, total int64, err error) {
+	tx := r.db.Table(r.kind.Table)
+	if q != "" {
+		tx = tx.Where("name ILIKE ?", "%"+q+"%")
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return
+	}
+	// books in the trash are not counted
+	err = tx.Select(r.kind.Table + ".*, (SELECT count(*) FROM " + r.kind.JoinTable +
+		" j JOIN books b ON b.id = j.book_id AND b.deleted_at IS NULL" +
+		" WHERE j." + r.kind.JoinKey + " = " + r.kind.Table + ".id) AS books").
+		Order("lower(name)").Offset((page - 1) * limit).Limit(limit).
+		Find(&items).Error
+	return
+}
+
+func (r *Repository) ByID(id uint) (*models.Term, error) {
+	var t models.Term
+	if err := r.db.Table(r.kind.Table).Where("id = ?", id).Take(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ByName matches case-insensitively.
+func (r *Repository) ByName(name string) (*models.Term, error) {
+	var t models.Term
+	if err := r.db.Table(r.kind.Table).Where("lower(name) = lower(?)", name).Take(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *Repository) nameTaken(name string, except uint) (bool, error) {
+	var n int64
+	err := r.db.Table(r.kind.Table).
+		Where("lower(name) = lower(?) AND id <> ?", name, except).
+		Count(&n).Error
+	return n > 0, err
+}
+
+func (r *Repository) Create(name string) (*models.Term, error) {
+	taken, err := r.nameTaken(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrNameTaken
+	}
+	t := models.Term{Name: name}
+	if err := r.db.Table(r.kind.Table).Create(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// BookHistory records how a change to a term shows in the books linked
+// to it. books.Repository implements it.
+type BookHistory interface {
+	// RecordChange runs change in tx and records it as a revision by
+	// userID of each book in ids.
+	RecordChange(tx *gorm.DB, ids []uint, userID uint, change func() error) error
+}
+
+// Rename renames t. The linked books show the new name, so each gets a
+// revision by userID in history, in the same transaction.
+func (r *Repository) Rename(t *models.Term, name string, history BookHistory, userID uint) error {
+	taken, err := r.nameTaken(name, t.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrNameTaken
+	}
+	t.Name = name
+	t.UpdatedAt = time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Table(r.kind.JoinTable).Where(r.kind.JoinKey+" = ?", t.ID).Order("book_id").Pluck("book_id", &ids).Error
+		if err != nil {
+			return err
+		}
+		return history.RecordChange(tx, ids, userID, func() error {
+			err := tx.Table(r.kind.Table).Where("id = ?", t.ID).
+				Updates(map[string]any{"name": t.Name, "updated_at": t.UpdatedAt}).Error
+			if err != nil {
+				return err
+			}
+			// the books show the new name, so their ETags must change
+			return tx.Exec("UPDATE books SET version = version + 1 WHERE id IN ?", ids).Error
+		})
+	})
+}
+
+// Delete refuses with ErrInUse while books still link to id, counting
+// books in the trash since they can be restored.
+func (r *Repository) Delete(id uint) error {
+	var n int64
+	if err := r.db.Table(r.kind.JoinTable).Where(r.kind.JoinKey+" = ?", id).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrInUse
+	}
+	return r.db.Table(r.kind.Table).Where("id = ?", id).Delete(&models.Term{}).Error
+}
+
+// Resolve returns the terms for ids, in order, and for names, creating
+// the names not seen before. Unknown ids yield gorm.ErrRecordNotFound.
+func (r *Repository) Resolve(ids []uint, names []string) ([]models.Term, error) {
+	var out []models.Term
+	seen := map[uint]bool{}
+	add := func(t *models.Term) {
+		if !seen[t.ID] {
+			seen[t.ID] = true
+			out = append(out, *t)
+		}
+	}
+	for _, id := range ids {
+		t, err := r.ByID(id)
+		if err != nil {
+			return nil, err
+		}
+		add(t)
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		t, err := r.byNameOrCreate(name)
+		if err != nil {
+			return nil, err
+		}
+		add(t)
+	}
+	return out, nil
+}
+
+// byNameOrCreate returns the term called name, creating it if there is
+// none. The insert is skipped, not failed, when another request has just
+// created the name, and that term is read instead.
+func (r *Repository) byNameOrCreate(name string) (*models.Term, error) {
+	var t models.Term
+	now := time.Now()
+	err := r.db.Raw("INSERT INTO "+r.kind.Table+" (name, created_at, updated_at) VALUES (?, ?, ?) "+
+		"ON CONFLICT (lower(name)) DO NOTHING RETURNING *", name, now, now).Scan(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	if t.ID != 0 {
+		return &t, nil
+	}
+	return r.ByName(name)
+}
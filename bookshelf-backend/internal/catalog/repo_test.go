@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/models"
+	"gorm.io/gorm"
+)
+
+func TestResolveConcurrentNewName(t *testing.T) {
+	db := testdb.Open(t)
+	repo := NewRepository(db, Authors)
+
+	const n = 10
+	ids := make([]uint, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// as in a book save, inside a transaction
+			err := db.Transaction(func(tx *gorm.DB) error {
+				terms, err := repo.WithDB(tx).Resolve(nil, []string{"Ursula K. Le Guin"})
+				if err == nil {
+					ids[i] = terms[0].ID
+				}
+				return err
+			})
+			if err != nil {
+				t.Errorf("resolve %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, id := range ids {
+		if id == 0 || id != ids[0] {
+			t.Fatalf("resolve %d got id %d, resolve 0 got %d", i, id, ids[0])
+		}
+	}
+	var count int64
+	db.Table(Authors.Table).Count(&count)
+	if count != 1 {
+		t.Errorf("%d authors stored, want 1", count)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo := NewRepository(testdb.Open(t), Publishers)
+	first, err := repo.Resolve(nil, []string{"Ace", " ", "Gollancz"})
+	if err != nil || len(first) != 2 {
+		t.Fatalf("Resolve = (%v, %v), want 2 terms", first, err)
+	}
+	// names match regardless of case, ids and names of one term give it once
+	again, err := repo.Resolve([]uint{first[1].ID}, []string{"ACE", "gollancz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Term{first[1], first[0]}
+	if len(again) != len(want) || again[0].ID != want[0].ID || again[1].ID != want[1].ID {
+		t.Errorf("Resolve again = %v, want %v", again, want)
+	}
+	if _, err := repo.Resolve([]uint{999999}, nil); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("unknown id: err = %v, want gorm.ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Package cursor turns pagination positions into opaque tokens that
+// clients hand back but can't read or forge.
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid cursor")
+
+var b64 = base64.RawURLEncoding
+
+// Signer encodes values as base64url(JSON) "." base64url(HMAC-SHA256).
+type Signer struct{ key []byte }
+
+func NewSigner(key []byte) *Signer { return &Signer{key: key} }
+
+func (s *Signer) mac(payload string) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// Encode returns the token for v.
+func (s *Signer) Encode(v any) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := b64.EncodeToString(raw)
+	return payload + "." + b64.EncodeToString(s.mac(payload)), nil
+}
+
+// Decode verifies tok and unmarshals it into v. Any damaged or foreign
+// token yields ErrInvalid.
+func (s *Signer) Decode(tok string, v any) error {
+	payload, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return ErrInvalid
+	}
+	got, err := b64.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, s.mac(payload)) {
+		return ErrInvalid
+	}
+	raw, err := b64.DecodeString(payload)
+	if err != nil || json.Unmarshal(raw, v) != nil {
+		return ErrInvalid
+	}
+	return nil
+}
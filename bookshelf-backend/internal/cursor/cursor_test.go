@@ -0,0 +1,57 @@
+package cursor
+
+import (
+	"strings"
+	"testing"
+)
+
+type position struct {
+	Value string `json:"v"`
+	ID    uint   `json:"i"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	tok, err := s.Encode(position{"Dune", 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got position
+	if err := s.Decode(tok, &got); err != nil || got != (position{"Dune", 7}) {
+		t.Fatalf("Decode = (%+v, %v)", got, err)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	tok, err := s.Encode(position{"Dune", 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(tok, ".")
+	forged := b64.EncodeToString([]byte(`{"v":"Dune","i":8}`))
+	foreign, err := NewSigner([]byte("other key")).Encode(position{"Dune", 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed, but not the JSON of a position
+	notJSON := b64.EncodeToString([]byte("Dune"))
+	notJSON += "." + b64.EncodeToString(s.mac(notJSON))
+
+	for name, tok := range map[string]string{
+		"empty":            "",
+		"no signature":     payload,
+		"empty signature":  payload + ".",
+		"changed payload":  forged + "." + sig,
+		"changed sig":      payload + "." + b64.EncodeToString([]byte("0123456789abcdef0123456789abcdef")),
+		"sig not base64":   payload + "." + sig + "!",
+		"other key":        foreign,
+		"swapped parts":    sig + "." + payload,
+		"payload not JSON": notJSON,
+	} {
+		var got position
+		if err := s.Decode(tok, &got); err != ErrInvalid {
+			t.Errorf("%s: Decode = (%+v, %v), want ErrInvalid", name, got, err)
+		}
+	}
+}
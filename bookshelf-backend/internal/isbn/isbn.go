@@ -0,0 +1,87 @@
+// Package isbn validates and normalizes International Standard Book
+// Numbers. ISBN-13 is the canonical form; ISBN-10 only exists for
+// 978-prefixed numbers.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid ISBN")
+
+// clean drops an "ISBN" label, hyphens and spaces and upper-cases a
+// trailing x.
+func clean(s string) string {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "", "‐", "", "‑", "").Replace(s))
+	return strings.TrimPrefix(strings.TrimPrefix(s, "ISBN:"), "ISBN")
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// check10 returns the ISBN-10 check character for the first 9 digits.
+func check10(s string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(s[i]-'0') * (10 - i)
+	}
+	c := (11 - sum%11) % 11
+	if c == 10 {
+		return 'X'
+	}
+	return byte('0' + c)
+}
+
+// check13 returns the ISBN-13 check digit for the first 12 digits.
+func check13(s string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(s[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Valid10 reports whether s (already cleaned) is a valid ISBN-10.
+func Valid10(s string) bool {
+	return len(s) == 10 && digits(s[:9]) && (s[9] == 'X' || digits(s[9:])) && check10(s) == s[9]
+}
+
+// Valid13 reports whether s (already cleaned) is a valid ISBN-13.
+func Valid13(s string) bool {
+	return len(s) == 13 && digits(s) && (strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")) && check13(s) == s[12]
+}
+
+// Normalize accepts an ISBN-10 or ISBN-13, with or without hyphens and
+// spaces, and returns it as a bare ISBN-13.
+func Normalize(s string) (string, error) {
+	s = clean(s)
+	switch {
+	case Valid13(s):
+		return s, nil
+	case Valid10(s):
+		s13 := "978" + s[:9]
+		return s13 + string(check13(s13)), nil
+	}
+	return "", ErrInvalid
+}
+
+// To10 converts a normalized ISBN-13 to ISBN-10. It reports false for
+// 979-prefixed numbers, which have no ISBN-10.
+func To10(s13 string) (string, bool) {
+	if !Valid13(s13) || !strings.HasPrefix(s13, "978") {
+		return "", false
+	}
+	s := s13[3:12]
+	return s + string(check10(s)), true
+}
@@ -0,0 +1,83 @@
+package isbn
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // "" for invalid
+	}{
+		// ISBN-13
+		{"9780306406157", "9780306406157"},
+		{"978-0-306-40615-7", "9780306406157"},
+		{"978 0 306 40615 7", "9780306406157"},
+		{"978‐0‐306‐40615‐7", "9780306406157"}, // unicode hyphens
+		{"ISBN 978-0-306-40615-7", "9780306406157"},
+		{"ISBN: 978-0-306-40615-7", "9780306406157"},
+		{"isbn:9780306406157", "9780306406157"},
+		{"979-10-90636-07-1", "9791090636071"},
+		// ISBN-10
+		{"0306406152", "9780306406157"},
+		{"0-306-40615-2", "9780306406157"},
+		{"ISBN 0-306-40615-2", "9780306406157"},
+		{"0-8044-2957-X", "9780804429573"},
+		{"080442957x", "9780804429573"},
+		// invalid
+		{"", ""},
+		{"ISBN", ""},
+		{"9780306406158", ""},  // check digit
+		{"0306406153", ""},     // check digit
+		{"9770306406151", ""},  // not a book prefix, though the check digit fits
+		{"978030640615X", ""},  // X only ends an ISBN-10
+		{"03064061X2", ""},     // X only as the check character
+		{"030640615", ""},      // too short
+		{"97803064061577", ""}, // too long
+		{"978-0-306-4O615-7", ""},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if tt.want == "" {
+			if err != ErrInvalid {
+				t.Errorf("Normalize(%q) = (%q, %v), want ErrInvalid", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = (%q, %v), want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestTo10(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"9780306406157", "0306406152", true},
+		{"9780804429573", "080442957X", true},
+		{"9791090636071", "", false}, // 979 has no ISBN-10
+		{"9780306406158", "", false}, // bad check digit
+		{"978-0-306-40615-7", "", false},
+		{"0306406152", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := To10(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("To10(%q) = (%q, %v), want (%q, %v)", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, s10 := range []string{"0306406152", "080442957X", "0451524934", "0441013597"} {
+		s13, err := Normalize(s10)
+		if err != nil {
+			t.Fatalf("Normalize(%q): %v", s10, err)
+		}
+		if back, ok := To10(s13); !ok || back != s10 {
+			t.Errorf("To10(Normalize(%q)) = (%q, %v)", s10, back, ok)
+		}
+	}
+}
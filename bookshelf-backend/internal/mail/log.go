@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogMailer never delivers anything. With Dir set each message is written
+// to its own .eml file there; otherwise it is printed to the server log.
+// Meant for local development and tests.
+type LogMailer struct {
+	Dir  string
+	From string
+}
+
+func (l *LogMailer) Send(_ context.Context, m Message) error {
+	if l.Dir == "" {
+		log.Printf("📧 mail to=%s subject=%q\n%s", m.To, m.Subject, m.Body)
+		return nil
+	}
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(l.Dir, name), render(l.From, m), 0o644)
+}
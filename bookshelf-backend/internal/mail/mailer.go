@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as password reset links.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+func env(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// FromEnv picks a Mailer based on MAIL_DRIVER:
+//
+//   - "smtp": SMTPMailer configured by SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+//     SMTP_PASSWORD and MAIL_FROM.
+//   - "log" (default): LogMailer writing to MAIL_LOG_DIR, or to the server
+//     log when that is unset.
+func FromEnv() (Mailer, error) {
+	from := env("MAIL_FROM", "Bookshelf <no-reply@bookshelf.local>")
+	switch driver := env("MAIL_DRIVER", "log"); driver {
+	case "smtp":
+		host := env("SMTP_HOST", "")
+		if host == "" {
+			return nil, fmt.Errorf("MAIL_DRIVER=smtp requires SMTP_HOST")
+		}
+		return &SMTPMailer{
+			Host:     host,
+			Port:     env("SMTP_PORT", "587"),
+			Username: env("SMTP_USERNAME", ""),
+			Password: env("SMTP_PASSWORD", ""),
+			From:     from,
+		}, nil
+	case "log":
+		return &LogMailer{Dir: env("MAIL_LOG_DIR", ""), From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OpenLibrary reads the Open Library Books API
+// (GET /api/books?bibkeys=ISBN:...&format=json&jscmd=data). BaseURL can
+// point at a mirror or a local stub serving the same format.
+type OpenLibrary struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewOpenLibrary(baseURL string) *OpenLibrary {
+	return &OpenLibrary{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type olNamed struct {
+	Name string `json:"name"`
+}
+
+type olBook struct {
+	Title         string    `json:"title"`
+	Subtitle      string    `json:"subtitle"`
+	Authors       []olNamed `json:"authors"`
+	Publishers    []olNamed `json:"publishers"`
+	NumberOfPages int       `json:"number_of_pages"`
+	PublishDate   string    `json:"publish_date"`
+	Cover         struct {
+		Small  string `json:"small"`
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+func (o *OpenLibrary) Lookup(ctx context.Context, isbn13 string) (*Metadata, error) {
+	key := "ISBN:" + isbn13
+	q := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.BaseURL+"/api/books?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "bookshelf (metadata lookup)")
+
+	res, err := o.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("openlibrary: unexpected status %s", res.Status)
+	}
+
+	var body map[string]olBook
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("openlibrary: decode response: %w", err)
+	}
+	b, ok := body[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	m := &Metadata{
+		ISBN13:        isbn13,
+		Title:         b.Title,
+		PageCount:     b.NumberOfPages,
+		PublishedDate: strings.TrimSpace(b.PublishDate),
+		Source:        "openlibrary",
+	}
+	if b.Subtitle != "" {
+		m.Title += ": " + b.Subtitle
+	}
+	for _, a := range b.Authors {
+		m.Authors = append(m.Authors, a.Name)
+	}
+	for _, p := range b.Publishers {
+		m.Publishers = append(m.Publishers, p.Name)
+	}
+	for _, u := range []string{b.Cover.Large, b.Cover.Medium, b.Cover.Small} {
+		if u != "" {
+			m.CoverURL = u
+			break
+		}
+	}
+	return m, nil
+}
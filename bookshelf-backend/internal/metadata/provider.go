@@ -0,0 +1,30 @@
+// Package metadata looks up bibliographic data for an ISBN in external
+// sources, used to pre-fill or create books.
+package metadata
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when the source has no record for the ISBN.
+var ErrNotFound = errors.New("no metadata found for this ISBN")
+
+// Metadata is what a source knows about an edition. Fields it doesn't
+// know are left empty.
+type Metadata struct {
+	ISBN13        string   `json:"isbn13"        example:"9780451524935"`
+	Title         string   `json:"title"         example:"1984"`
+	Authors       []string `json:"authors"       example:"George Orwell"`
+	Publishers    []string `json:"publishers"    example:"Signet Classic"`
+	PageCount     int      `json:"pageCount"     example:"328"`
+	PublishedDate string   `json:"publishedDate" example:"1961"`
+	CoverURL      string   `json:"coverUrl"      example:"https://covers.openlibrary.org/b/id/153541-L.jpg"`
+	Source        string   `json:"source"        example:"openlibrary"`
+}
+
+// Provider is a bibliographic source (the "MetadataProvider").
+type Provider interface {
+	// Lookup returns metadata for a normalized ISBN-13, or ErrNotFound.
+	Lookup(ctx context.Context, isbn13 string) (*Metadata, error)
+}
@@ -0,0 +1,242 @@
+// Package migrate applies the versioned SQL files from the migrations
+// package and records them in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lockKey is the pg_advisory_lock key held while migrating, so replicas
+// starting at the same time apply each migration exactly once.
+const lockKey int64 = 0x626f6f6b73 // "books"
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version, read from NNNN_name.up.sql and
+// NNNN_name.down.sql.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+// Status describes a migration known to the binary or recorded in the
+// database (Missing: applied, but its files are gone).
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+	Modified  bool // applied with a different checksum
+	Missing   bool
+}
+
+// ErrChecksum is returned by Up when an applied migration was edited.
+var ErrChecksum = errors.New("applied migration has been modified")
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New loads the migrations in the root of fsys.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	ms, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		v, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[v]
+		if mig == nil {
+			mig = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has files named %q and %q", v, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+			sum := sha256.Sum256(body)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// withLock runs fn on a single connection holding the advisory lock, with
+// schema_migrations created and its rows loaded.
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn, map[int64]applied) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// session level, so it must be released on the same connection
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    bigint PRIMARY KEY,
+		name       text NOT NULL,
+		checksum   text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	done := map[int64]applied{}
+	for rows.Next() {
+		var v int64
+		var a applied
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		done[v] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+// exec runs sql and updates schema_migrations in one transaction.
+func exec(ctx context.Context, conn *sql.Conn, body, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration in order and returns how many ran.
+// It refuses to run if an applied migration's file has changed.
+func (m *Migrator) Up(ctx context.Context, logf func(string, ...any)) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sql.Conn, done map[int64]applied) error {
+		for _, mig := range m.migrations {
+			if a, ok := done[mig.Version]; ok && a.checksum != mig.Checksum {
+				return fmt.Errorf("%w: %d_%s", ErrChecksum, mig.Version, mig.Name)
+			}
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			start := time.Now()
+			if err := exec(ctx, conn, mig.Up,
+				`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+				mig.Version, mig.Name, mig.Checksum); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			logf("applied %d_%s in %s", mig.Version, mig.Name, time.Since(start).Round(time.Millisecond))
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down rolls back the steps most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int, logf func(string, ...any)) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sql.Conn, done map[int64]applied) error {
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if err := exec(ctx, conn, mig.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+				return fmt.Errorf("rollback %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			logf("rolled back %d_%s", mig.Version, mig.Name)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status lists every migration, oldest first.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.withLock(ctx, func(_ *sql.Conn, done map[int64]applied) error {
+		for _, mig := range m.migrations {
+			s := Status{Version: mig.Version, Name: mig.Name}
+			if a, ok := done[mig.Version]; ok {
+				s.AppliedAt = &a.appliedAt
+				s.Modified = a.checksum != mig.Checksum
+				delete(done, mig.Version)
+			}
+			out = append(out, s)
+		}
+		for v, a := range done {
+			at := a.appliedAt
+			out = append(out, Status{Version: v, Name: a.name, AppliedAt: &at, Missing: true})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+		return nil
+	})
+	return out, err
+}
@@ -0,0 +1,197 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/giovannyptr/bookshelf/internal/migrate"
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/migrations"
+)
+
+func file(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+// shelves is a pair of migrations for the tests to apply.
+func shelves() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_shelves.up.sql":    file(`CREATE TABLE shelves (id serial PRIMARY KEY)`),
+		"0001_shelves.down.sql":  file(`DROP TABLE shelves`),
+		"0002_labels.up.sql":     file(`ALTER TABLE shelves ADD COLUMN label text`),
+		"0002_labels.down.sql":   file(`ALTER TABLE shelves DROP COLUMN label`),
+		"README.md":              file("not a migration"),
+		"0003_draft.up.sql.orig": file("ignored"),
+		"embed.go":               file("package migrations"),
+	}
+}
+
+func nop(string, ...any) {}
+
+func TestNewRejectsBadFiles(t *testing.T) {
+	if _, err := migrate.New(nil, shelves()); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	noDown := shelves()
+	delete(noDown, "0002_labels.down.sql")
+	if _, err := migrate.New(nil, noDown); err == nil {
+		t.Error("New accepted a migration without a down file")
+	}
+	renamed := shelves()
+	renamed["0002_tags.down.sql"] = renamed["0002_labels.down.sql"]
+	delete(renamed, "0002_labels.down.sql")
+	if _, err := migrate.New(nil, renamed); err == nil {
+		t.Error("New accepted a version with two names")
+	}
+}
+
+// open returns a migrator for fsys on a fresh, empty schema.
+func open(t *testing.T, fsys fstest.MapFS) (*migrate.Migrator, *sql.DB) {
+	t.Helper()
+	db, err := testdb.Empty(t).DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMigrator(t, db, fsys), db
+}
+
+func newMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS) *migrate.Migrator {
+	t.Helper()
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func applied(t *testing.T, m *migrate.Migrator) []int64 {
+	t.Helper()
+	st, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []int64
+	for _, s := range st {
+		if s.AppliedAt != nil {
+			out = append(out, s.Version)
+		}
+	}
+	return out
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	m, db := open(t, shelves())
+
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 2 || st[0].Version != 1 || st[0].Name != "shelves" || st[0].AppliedAt != nil || st[1].Version != 2 {
+		t.Fatalf("status before Up = %+v", st)
+	}
+	if n, err := m.Up(ctx, nop); err != nil || n != 2 {
+		t.Fatalf("Up = (%d, %v), want 2", n, err)
+	}
+	if _, err := db.Exec(`INSERT INTO shelves (label) VALUES ('fiction')`); err != nil {
+		t.Fatalf("schema after Up: %v", err)
+	}
+	if n, err := m.Up(ctx, nop); err != nil || n != 0 {
+		t.Fatalf("second Up = (%d, %v), want 0", n, err)
+	}
+
+	if n, err := m.Down(ctx, 1, nop); err != nil || n != 1 {
+		t.Fatalf("Down = (%d, %v), want 1", n, err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied after Down = %v, want [1]", got)
+	}
+	if _, err := db.Exec(`INSERT INTO shelves (label) VALUES ('fiction')`); err == nil {
+		t.Error("label column still there after Down")
+	}
+	if n, err := m.Up(ctx, nop); err != nil || n != 1 {
+		t.Fatalf("Up after Down = (%d, %v), want 1", n, err)
+	}
+	if n, err := m.Down(ctx, 5, nop); err != nil || n != 2 {
+		t.Fatalf("Down past the first = (%d, %v), want 2", n, err)
+	}
+	if got := applied(t, m); len(got) != 0 {
+		t.Errorf("applied after rolling back everything = %v", got)
+	}
+}
+
+func TestUpFailureRollsBack(t *testing.T) {
+	ctx := context.Background()
+	fsys := shelves()
+	fsys["0002_labels.up.sql"] = file(`ALTER TABLE shelves ADD COLUMN label text; SELECT no_such_function()`)
+	m, db := open(t, fsys)
+	if n, err := m.Up(ctx, nop); err == nil || n != 1 {
+		t.Fatalf("Up = (%d, %v), want 1 applied and an error", n, err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("applied = %v, want [1]", got)
+	}
+	if _, err := db.Exec(`SELECT label FROM shelves`); err == nil {
+		t.Error("failed migration left its column")
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	m, db := open(t, shelves())
+	if _, err := m.Up(ctx, nop); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := shelves()
+	edited["0001_shelves.up.sql"] = file(`CREATE TABLE shelves (id bigserial PRIMARY KEY)`)
+	edited["0003_colors.up.sql"] = file(`ALTER TABLE shelves ADD COLUMN color text`)
+	edited["0003_colors.down.sql"] = file(`ALTER TABLE shelves DROP COLUMN color`)
+	m = newMigrator(t, db, edited)
+	if n, err := m.Up(ctx, nop); !errors.Is(err, migrate.ErrChecksum) || n != 0 {
+		t.Fatalf("Up with an edited migration = (%d, %v), want ErrChecksum", n, err)
+	}
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st[0].Modified || st[1].Modified || st[2].AppliedAt != nil {
+		t.Errorf("status = %+v, want 1 modified and 3 pending", st)
+	}
+
+	// an applied migration whose files are gone is listed as missing
+	gone := shelves()
+	delete(gone, "0002_labels.up.sql")
+	delete(gone, "0002_labels.down.sql")
+	st, err = newMigrator(t, db, gone).Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 2 || st[1].Version != 2 || !st[1].Missing || st[1].Name != "labels" {
+		t.Errorf("status = %+v, want 2 missing", st)
+	}
+}
+
+// The shipped migrations roll back cleanly and apply again.
+func TestMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db, err := testdb.Empty(t).DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := m.Up(ctx, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down, err := m.Down(ctx, n, nop); err != nil || down != n {
+		t.Fatalf("Down = (%d, %v), want %d", down, err, n)
+	}
+	if again, err := m.Up(ctx, nop); err != nil || again != n {
+		t.Fatalf("Up after Down = (%d, %v), want %d", again, err, n)
+	}
+}
@@ -0,0 +1,287 @@
+package users
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/api"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+// Accounts is what the admin endpoints need from the auth package;
+// *auth.Handler implements it.
+type Accounts interface {
+	EndSessions(uid uint) error
+	SendPasswordReset(u *models.User) error
+}
+
+type Handler struct {
+	repo     *Repository
+	accounts Accounts
+	// currentUser returns the id of the authenticated admin.
+	currentUser func(*gin.Context) (uint, bool)
+}
+
+func NewHandler(repo *Repository, accounts Accounts, currentUser func(*gin.Context) (uint, bool)) *Handler {
+	return &Handler{repo: repo, accounts: accounts, currentUser: currentUser}
+}
+
+// RegisterRoutes mounts /users behind mw, which is expected to
+// authenticate the caller and require admin rights.
+func (h *Handler) RegisterRoutes(r *gin.Engine, mw ...gin.HandlerFunc) {
+	g := r.Group("/users", mw...)
+	g.GET("", h.List)
+	g.GET("/:id", h.Detail)
+	g.PUT("/:id/role", h.SetRole)
+	g.POST("/:id/disable", h.Disable)
+	g.POST("/:id/enable", h.Enable)
+	g.POST("/:id/reset-password", h.ForceReset)
+	g.DELETE("/:id", h.Delete)
+}
+
+// load fetches the :id user or answers 404.
+func (h *Handler) load(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+	u, err := h.repo.ByID(uint(id))
+	if err != nil {
+		api.Abort(c, api.NotFound(err, "user not found"))
+		return nil, false
+	}
+	return u, true
+}
+
+// notSelf refuses actions that would let admins lock themselves out.
+func (h *Handler) notSelf(c *gin.Context, u *models.User, action string) bool {
+	if uid, _ := h.currentUser(c); uid == u.ID {
+		api.Fail(c, http.StatusBadRequest, "you cannot "+action+" your own account")
+		return false
+	}
+	return true
+}
+
+// list godoc
+// @Summary List users
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   q      query string false "Search by email/name"
+// @Param   role   query string false "Filter by role"
+// @Param   status query string false "active or disabled"
+// @Param   page   query int    false "Page number"  default(1)
+// @Param   limit  query int    false "Page size (1-100)" default(20)
+// @Success 200 {object} api.PagedUsers
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Router  /users [get]
+func (h *Handler) List(c *gin.Context) {
+	f := ListFilter{
+		Query:  strings.TrimSpace(c.Query("q")),
+		Role:   c.Query("role"),
+		Status: c.Query("status"),
+	}
+	if f.Status != "" && f.Status != "active" && f.Status != "disabled" {
+		api.Fail(c, http.StatusBadRequest, "status must be active or disabled")
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	items, total, err := h.repo.List(f, page, limit)
+	if err != nil {
+		api.Abort(c, api.Internal(err, "failed to list users"))
+		return
+	}
+	api.OK(c, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// detail godoc
+// @Summary Get a user
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /users/{id} [get]
+func (h *Handler) Detail(c *gin.Context) {
+	if u, ok := h.load(c); ok {
+		api.OK(c, u)
+	}
+}
+
+type roleDTO struct {
+	Role string `json:"role" binding:"required,oneof=admin editor viewer" example:"editor"`
+}
+
+// setRole godoc
+// @Summary Change a user's role
+// @Description Takes effect on the user's next request. Admins cannot change their own role.
+// @Tags    users
+// @Accept  json
+// @Produce json
+// @Security BearerAuth
+// @Param   id      path int     true "User ID"
+// @Param   payload body roleDTO true "New role (admin, editor or viewer)"
+// @Success 200 {object} models.User
+// @Failure 400 {object} api.FieldErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /users/{id}/role [put]
+func (h *Handler) SetRole(c *gin.Context) {
+	var in roleDTO
+	if !api.BindJSON(c, &in) {
+		return
+	}
+	u, ok := h.load(c)
+	if !ok || !h.notSelf(c, u, "change the role of") {
+		return
+	}
+	if u.Role != in.Role {
+		log.Printf("🔁 Role of %s changed from %s to %s", u.Email, u.Role, in.Role)
+		u.Role = in.Role
+		if err := h.repo.Save(u); err != nil {
+			api.Abort(c, api.Internal(err, "failed to update user"))
+			return
+		}
+	}
+	api.OK(c, u)
+}
+
+// disable godoc
+// @Summary Disable a user
+// @Description Blocks login and ends every session and API key of the user.
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /users/{id}/disable [post]
+func (h *Handler) Disable(c *gin.Context) {
+	u, ok := h.load(c)
+	if !ok || !h.notSelf(c, u, "disable") {
+		return
+	}
+	if u.DisabledAt == nil {
+		now := time.Now()
+		u.DisabledAt = &now
+		if err := h.repo.Save(u); err != nil {
+			api.Abort(c, api.Internal(err, "failed to update user"))
+			return
+		}
+	}
+	if err := h.accounts.EndSessions(u.ID); err != nil {
+		log.Printf("⚠️  failed to end sessions of user %d: %v", u.ID, err)
+	}
+	api.OK(c, u)
+}
+
+// enable godoc
+// @Summary Re-enable a disabled user
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "the user deleted the account"
+// @Router  /users/{id}/enable [post]
+func (h *Handler) Enable(c *gin.Context) {
+	u, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if u.AnonymizedAt != nil {
+		api.Fail(c, http.StatusConflict, "the user deleted this account; it can't be re-enabled")
+		return
+	}
+	if u.DisabledAt != nil {
+		u.DisabledAt = nil
+		if err := h.repo.Save(u); err != nil {
+			api.Abort(c, api.Internal(err, "failed to update user"))
+			return
+		}
+	}
+	api.OK(c, u)
+}
+
+// forceReset godoc
+// @Summary Force a password reset
+// @Description Invalidates the current password, ends every session and API key and mails the user a reset link.
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /users/{id}/reset-password [post]
+func (h *Handler) ForceReset(c *gin.Context) {
+	u, ok := h.load(c)
+	if !ok {
+		return
+	}
+	// an empty hash never matches, so only the mailed link gets back in
+	u.Password = ""
+	if err := h.repo.Save(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to update user"))
+		return
+	}
+	if err := h.accounts.EndSessions(u.ID); err != nil {
+		log.Printf("⚠️  failed to end sessions of user %d: %v", u.ID, err)
+	}
+	if err := h.accounts.SendPasswordReset(u); err != nil {
+		api.Abort(c, api.Internal(err, "failed to issue reset link"))
+		return
+	}
+	api.OK(c, gin.H{"message": "password reset link sent"})
+}
+
+// delete godoc
+// @Summary Delete a user
+// @Description Removes the account and its sessions, API keys and recovery codes. Admins cannot delete themselves.
+// @Tags    users
+// @Produce json
+// @Security BearerAuth
+// @Param   id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router  /users/{id} [delete]
+func (h *Handler) Delete(c *gin.Context) {
+	u, ok := h.load(c)
+	if !ok || !h.notSelf(c, u, "delete") {
+		return
+	}
+	if err := h.repo.Delete(u.ID); err != nil {
+		api.Abort(c, api.Internal(err, "failed to delete user"))
+		return
+	}
+	log.Printf("🗑️  Deleted user %s", u.Email)
+	api.OK(c, gin.H{"message": "user deleted"})
+}
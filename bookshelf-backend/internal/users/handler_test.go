@@ -0,0 +1,49 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestEnableRefusesDeletedAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := NewRepository(testdb.Open(t))
+	h := NewHandler(repo, nil, func(*gin.Context) (uint, bool) { return 0, false })
+	r := gin.New()
+	h.RegisterRoutes(r)
+
+	disabled := &models.User{Email: "disabled@mail.com", Name: "Disabled", Role: models.RoleViewer}
+	deleted := &models.User{Email: "deleted@mail.com", Name: "Deleted", Role: models.RoleAdmin}
+	for _, u := range []*models.User{disabled, deleted} {
+		if err := repo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.db.Model(disabled).Update("disabled_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Anonymize(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		u    *models.User
+		want int
+	}{{disabled, http.StatusOK}, {deleted, http.StatusConflict}} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/users/%d/enable", tt.u.ID), nil))
+		if w.Code != tt.want {
+			t.Errorf("enable %s = %d %s, want %d", tt.u.Name, w.Code, w.Body, tt.want)
+		}
+	}
+	if u, err := repo.ByID(deleted.ID); err != nil || u.DisabledAt == nil {
+		t.Errorf("deleted account after enable: (%+v, %v), want still disabled", u, err)
+	}
+}
@@ -1,6 +1,10 @@
 package users
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/giovannyptr/bookshelf/models"
 	"gorm.io/gorm"
 )
@@ -8,7 +12,6 @@ import (
 type Repository struct{ db *gorm.DB }
 
 func NewRepository(db *gorm.DB) *Repository { return &Repository{db: db} }
-func (r *Repository) Migrate() error        { return r.db.AutoMigrate(&models.User{}) }
 
 func (r *Repository) ByEmail(email string) (*models.User, error) {
 	var u models.User
@@ -18,7 +21,16 @@ func (r *Repository) ByEmail(email string) (*models.User, error) {
 	return &u, nil
 }
 
+func (r *Repository) ByOIDCSubject(subject string) (*models.User, error) {
+	var u models.User
+	if err := r.db.Where("oidc_subject = ?", subject).First(&u).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 func (r *Repository) Create(u *models.User) error { return r.db.Create(u).Error }
+func (r *Repository) Save(u *models.User) error   { return r.db.Save(u).Error }
 func (r *Repository) ByID(id uint) (*models.User, error) {
 	var u models.User
 	if err := r.db.First(&u, id).Error; err != nil {
@@ -26,3 +38,114 @@ func (r *Repository) ByID(id uint) (*models.User, error) {
 	}
 	return &u, nil
 }
+
+// RecordLogin stamps a successful login and clears the failure counter.
+func (r *Repository) RecordLogin(id uint) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).
+		Updates(map[string]any{"last_login_at": time.Now(), "failed_login_count": 0}).Error
+}
+
+// RecordLoginFailure bumps the failed login counter.
+func (r *Repository) RecordLoginFailure(id uint) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).
+		UpdateColumn("failed_login_count", gorm.Expr("failed_login_count + 1")).Error
+}
+
+// AdvanceTOTPStep records step as the last accepted TOTP time step. It
+// reports false when step is not newer, i.e. the code was already used.
+func (r *Repository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	res := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		UpdateColumn("totp_last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListFilter narrows List; empty fields match everything.
+type ListFilter struct {
+	Query  string // matched against email and name
+	Role   string
+	Status string // "active" or "disabled"
+}
+
+func (r *Repository) List(f ListFilter, page, limit int) (items []models.User, total int64, err error) {
+	tx := r.db.Model(&models.User{})
+	if f.Query != "" {
+		like := "%" + escapeLike(f.Query) + "%"
+		tx = tx.Where(`email ILIKE ? ESCAPE '\' OR name ILIKE ? ESCAPE '\'`, like, like)
+	}
+	if f.Role != "" {
+		tx = tx.Where("role = ?", f.Role)
+	}
+	switch f.Status {
+	case "active":
+		tx = tx.Where("disabled_at IS NULL")
+	case "disabled":
+		tx = tx.Where("disabled_at IS NOT NULL")
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return
+	}
+	err = tx.Order("id ASC").Offset((page - 1) * limit).Limit(limit).Find(&items).Error
+	return
+}
+
+// escapeLike makes s match itself in a LIKE pattern with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// deleteCredentials removes every session, key and token row of user id.
+func deleteCredentials(tx *gorm.DB, id uint) error {
+	for _, m := range []any{
+		&models.RefreshToken{}, &models.ActionToken{}, &models.RecoveryCode{}, &models.APIKey{},
+	} {
+		if err := tx.Where("user_id = ?", id).Delete(m).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the user together with its credentials.
+func (r *Repository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteCredentials(tx, id); err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, id).Error
+	})
+}
+
+// Anonymize deletes the credentials of user id and scrubs its personal
+// data, keeping the row itself (see models.User.AnonymizedAt). The role
+// drops to viewer, so the row never counts as an admin.
+func (r *Repository) Anonymize(id uint) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteCredentials(tx, id); err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]any{
+			"email":             fmt.Sprintf("deleted-%d@users.invalid", id),
+			"name":              "Deleted user",
+			"role":              models.RoleViewer,
+			"password":          "",
+			"pending_email":     nil,
+			"totp_secret":       "",
+			"totp_enabled_at":   nil,
+			"oidc_subject":      nil,
+			"email_verified_at": nil,
+			"disabled_at":       now,
+			"anonymized_at":     now,
+		}).Error
+	})
+}
+
+// CountActiveAdmins counts admins that are neither disabled nor deleted.
+func (r *Repository) CountActiveAdmins() (int64, error) {
+	var n int64
+	err := r.db.Model(&models.User{}).
+		Where("role = ? AND disabled_at IS NULL AND anonymized_at IS NULL", models.RoleAdmin).
+		Count(&n).Error
+	return n, err
+}
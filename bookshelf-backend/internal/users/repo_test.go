@@ -0,0 +1,67 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/giovannyptr/bookshelf/internal/testdb"
+	"github.com/giovannyptr/bookshelf/models"
+)
+
+func TestEscapeLike(t *testing.T) {
+	for in, want := range map[string]string{
+		"ann":        "ann",
+		"100%":       `100\%`,
+		"a_b":        `a\_b`,
+		`back\slash`: `back\\slash`,
+	} {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestListMatchesQueryLiterally(t *testing.T) {
+	r := NewRepository(testdb.Open(t))
+	for _, u := range []*models.User{
+		{Email: "ann@mail.com", Name: "Ann", Role: models.RoleViewer},
+		{Email: "bob_smith@mail.com", Name: "Bob 100% Smith", Role: models.RoleViewer},
+	} {
+		if err := r.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q, want := range map[string]int64{"_": 1, "%": 1, "b_s": 1, "n@": 1, `\`: 0, "mail": 2} {
+		if _, total, err := r.List(ListFilter{Query: q}, 1, 10); err != nil || total != want {
+			t.Errorf("List(q=%q) total = (%d, %v), want %d", q, total, err, want)
+		}
+	}
+}
+
+func TestAnonymizedAdminsDoNotCount(t *testing.T) {
+	r := NewRepository(testdb.Open(t))
+	ghost := &models.User{Email: "ghost@mail.com", Name: "Ghost", Role: models.RoleAdmin}
+	active := &models.User{Email: "real@mail.com", Name: "Real", Role: models.RoleAdmin}
+	for _, u := range []*models.User{ghost, active} {
+		if err := r.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Anonymize(ghost.ID); err != nil {
+		t.Fatal(err)
+	}
+	u, err := r.ByID(ghost.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != models.RoleViewer {
+		t.Errorf("anonymized user keeps role %s", u.Role)
+	}
+	// even with the row re-enabled and promoted by hand
+	if err := r.db.Model(&models.User{}).Where("id = ?", ghost.ID).
+		Updates(map[string]any{"disabled_at": nil, "role": models.RoleAdmin}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.CountActiveAdmins(); err != nil || n != 1 {
+		t.Errorf("CountActiveAdmins = (%d, %v), want 1", n, err)
+	}
+}
@@ -0,0 +1,26 @@
+-- Schema as created by GORM AutoMigrate before migrations existed. IF NOT
+-- EXISTS lets databases created that way adopt the migration history.
+
+CREATE TABLE IF NOT EXISTS users (
+    id         bigserial PRIMARY KEY,
+    email      text,
+    password   text,
+    name       text,
+    role       text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS books (
+    id         bigserial PRIMARY KEY,
+    title      text NOT NULL,
+    author     text,
+    category   text,
+    price      numeric,
+    stock      bigint,
+    cover_url  text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_books_title ON books (title);
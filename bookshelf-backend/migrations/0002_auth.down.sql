@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS action_tokens;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+
+ALTER TABLE users
+    ALTER COLUMN role DROP DEFAULT,
+    DROP COLUMN IF EXISTS email_verified_at,
+    DROP COLUMN IF EXISTS pending_email,
+    DROP COLUMN IF EXISTS last_login_at,
+    DROP COLUMN IF EXISTS failed_login_count,
+    DROP COLUMN IF EXISTS disabled_at,
+    DROP COLUMN IF EXISTS anonymized_at,
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS totp_enabled_at,
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS oidc_subject;
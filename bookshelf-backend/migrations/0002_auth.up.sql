@@ -0,0 +1,91 @@
+-- Roles, sessions, mailed links, login throttling, 2FA, API keys, OIDC
+-- linking and account administration. IF NOT EXISTS for the same reason as
+-- in 0001.
+
+ALTER TABLE users
+    ALTER COLUMN role SET DEFAULT 'viewer',
+    ADD COLUMN IF NOT EXISTS email_verified_at  timestamptz,
+    ADD COLUMN IF NOT EXISTS pending_email      text,
+    ADD COLUMN IF NOT EXISTS last_login_at      timestamptz,
+    ADD COLUMN IF NOT EXISTS failed_login_count bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS disabled_at        timestamptz,
+    ADD COLUMN IF NOT EXISTS anonymized_at      timestamptz,
+    ADD COLUMN IF NOT EXISTS totp_secret        text,
+    ADD COLUMN IF NOT EXISTS totp_enabled_at    timestamptz,
+    ADD COLUMN IF NOT EXISTS totp_last_step     bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS oidc_subject       text;
+CREATE INDEX IF NOT EXISTS idx_users_disabled_at ON users (disabled_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users (oidc_subject);
+
+-- "user" was the only role before roles existed and grants the same as viewer.
+UPDATE users SET role = 'viewer' WHERE role IS NULL OR role IN ('', 'user');
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id             bigserial PRIMARY KEY,
+    user_id        bigint NOT NULL,
+    family_id      text NOT NULL,
+    token_hash     text NOT NULL,
+    expires_at     timestamptz NOT NULL,
+    revoked_at     timestamptz,
+    replaced_by_id bigint,
+    amr            text,
+    created_at     timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        text PRIMARY KEY,
+    user_id    bigint,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS action_tokens (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    purpose    text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_action_tokens_user_id ON action_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_action_tokens_purpose ON action_tokens (purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_action_tokens_token_hash ON action_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    code_hash  text NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_code_hash ON recovery_codes (code_hash);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           bigserial PRIMARY KEY,
+    user_id      bigint NOT NULL,
+    name         text NOT NULL,
+    prefix       text NOT NULL,
+    key_hash     text NOT NULL,
+    scopes       text NOT NULL,
+    last_used_at timestamptz,
+    expires_at   timestamptz,
+    revoked_at   timestamptz,
+    created_at   timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys (prefix);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key             text PRIMARY KEY,
+    failures        bigint NOT NULL DEFAULT 0,
+    last_failure_at timestamptz NOT NULL,
+    locked_until    timestamptz,
+    updated_at      timestamptz
+);
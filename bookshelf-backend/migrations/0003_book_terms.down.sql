@@ -0,0 +1,25 @@
+ALTER TABLE books ADD COLUMN author text, ADD COLUMN category text;
+
+-- Books with several authors or categories keep them comma separated.
+UPDATE books b SET author = x.names
+FROM (
+    SELECT ba.book_id, string_agg(a.name, ', ' ORDER BY a.name) AS names
+    FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+    GROUP BY ba.book_id
+) x
+WHERE x.book_id = b.id;
+
+UPDATE books b SET category = x.names
+FROM (
+    SELECT bc.book_id, string_agg(c.name, ', ' ORDER BY c.name) AS names
+    FROM book_categories bc JOIN categories c ON c.id = bc.category_id
+    GROUP BY bc.book_id
+) x
+WHERE x.book_id = b.id;
+
+DROP TABLE book_publishers;
+DROP TABLE book_categories;
+DROP TABLE book_authors;
+DROP TABLE publishers;
+DROP TABLE categories;
+DROP TABLE authors;
@@ -0,0 +1,73 @@
+-- Authors, categories and publishers become entities linked to books
+-- instead of free-text columns on books.
+
+CREATE TABLE authors (
+    id         bigserial PRIMARY KEY,
+    name       text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX idx_authors_name ON authors (lower(name));
+
+CREATE TABLE categories (
+    id         bigserial PRIMARY KEY,
+    name       text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX idx_categories_name ON categories (lower(name));
+
+CREATE TABLE publishers (
+    id         bigserial PRIMARY KEY,
+    name       text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX idx_publishers_name ON publishers (lower(name));
+
+CREATE TABLE book_authors (
+    book_id   bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    author_id bigint NOT NULL REFERENCES authors (id) ON DELETE RESTRICT,
+    PRIMARY KEY (book_id, author_id)
+);
+CREATE INDEX idx_book_authors_author_id ON book_authors (author_id);
+
+CREATE TABLE book_categories (
+    book_id     bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories (id) ON DELETE RESTRICT,
+    PRIMARY KEY (book_id, category_id)
+);
+CREATE INDEX idx_book_categories_category_id ON book_categories (category_id);
+
+CREATE TABLE book_publishers (
+    book_id      bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    publisher_id bigint NOT NULL REFERENCES publishers (id) ON DELETE RESTRICT,
+    PRIMARY KEY (book_id, publisher_id)
+);
+CREATE INDEX idx_book_publishers_publisher_id ON book_publishers (publisher_id);
+
+-- Existing strings: spellings differing only in case or surrounding
+-- whitespace collapse into one entity, named after the earliest book.
+INSERT INTO authors (name)
+SELECT DISTINCT ON (lower(btrim(author))) btrim(author)
+FROM books
+WHERE btrim(coalesce(author, '')) <> ''
+ORDER BY lower(btrim(author)), id;
+
+INSERT INTO book_authors (book_id, author_id)
+SELECT b.id, a.id
+FROM books b
+JOIN authors a ON lower(a.name) = lower(btrim(b.author));
+
+INSERT INTO categories (name)
+SELECT DISTINCT ON (lower(btrim(category))) btrim(category)
+FROM books
+WHERE btrim(coalesce(category, '')) <> ''
+ORDER BY lower(btrim(category)), id;
+
+INSERT INTO book_categories (book_id, category_id)
+SELECT b.id, c.id
+FROM books b
+JOIN categories c ON lower(c.name) = lower(btrim(b.category));
+
+ALTER TABLE books DROP COLUMN author, DROP COLUMN category;
@@ -0,0 +1 @@
+ALTER TABLE books DROP COLUMN isbn13, DROP COLUMN isbn10;
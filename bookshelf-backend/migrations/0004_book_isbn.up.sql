@@ -0,0 +1,7 @@
+-- isbn13 is the canonical identifier; isbn10 is derived from it for
+-- 978-prefixed numbers. Books without an ISBN keep both NULL.
+ALTER TABLE books
+    ADD COLUMN isbn13 text,
+    ADD COLUMN isbn10 text;
+CREATE UNIQUE INDEX idx_books_isbn13 ON books (isbn13);
+CREATE INDEX idx_books_isbn10 ON books (isbn10);
@@ -0,0 +1 @@
+ALTER TABLE books DROP COLUMN page_count, DROP COLUMN published_date;
@@ -0,0 +1,5 @@
+-- Edition details, mostly filled from metadata lookups. published_date is
+-- free text because sources give anything from "1949" to "June 8, 1949".
+ALTER TABLE books
+    ADD COLUMN page_count integer,
+    ADD COLUMN published_date text;
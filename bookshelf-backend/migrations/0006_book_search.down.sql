@@ -0,0 +1,9 @@
+DROP TRIGGER authors_search_update ON authors;
+DROP TRIGGER book_authors_search_update ON book_authors;
+DROP TRIGGER books_search_update ON books;
+DROP FUNCTION authors_search_update();
+DROP FUNCTION book_authors_search_update();
+DROP FUNCTION books_search_update();
+DROP FUNCTION book_search_vector(text, text, text);
+DROP FUNCTION book_author_names(bigint);
+ALTER TABLE books DROP COLUMN search, DROP COLUMN description;
@@ -0,0 +1,73 @@
+-- Full-text search over title (weight A), author names (B) and
+-- description (C). Author names live in other tables, so books.search is
+-- kept up to date by triggers instead of being a generated column. The
+-- 'english' configuration must match searchConfig in internal/books.
+
+ALTER TABLE books
+    ADD COLUMN description text NOT NULL DEFAULT '',
+    ADD COLUMN search tsvector;
+
+CREATE FUNCTION book_author_names(book bigint) RETURNS text
+LANGUAGE sql STABLE AS $$
+    SELECT coalesce(string_agg(a.name, ' '), '')
+    FROM book_authors ba
+    JOIN authors a ON a.id = ba.author_id
+    WHERE ba.book_id = book
+$$;
+
+CREATE FUNCTION book_search_vector(title text, authors text, description text) RETURNS tsvector
+LANGUAGE sql IMMUTABLE AS $$
+    SELECT setweight(to_tsvector('english', coalesce(title, '')), 'A')
+        || setweight(to_tsvector('english', coalesce(authors, '')), 'B')
+        || setweight(to_tsvector('english', coalesce(description, '')), 'C')
+$$;
+
+-- title or description changed on the book itself
+CREATE FUNCTION books_search_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.search := book_search_vector(NEW.title, book_author_names(NEW.id), NEW.description);
+    RETURN NEW;
+END
+$$;
+
+CREATE TRIGGER books_search_update
+    BEFORE INSERT OR UPDATE OF title, description ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_update();
+
+-- a book gained or lost an author
+CREATE FUNCTION book_authors_search_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    book bigint := CASE WHEN TG_OP = 'DELETE' THEN OLD.book_id ELSE NEW.book_id END;
+BEGIN
+    UPDATE books
+    SET search = book_search_vector(title, book_author_names(id), description)
+    WHERE id = book;
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER book_authors_search_update
+    AFTER INSERT OR DELETE ON book_authors
+    FOR EACH ROW EXECUTE FUNCTION book_authors_search_update();
+
+-- an author was renamed
+CREATE FUNCTION authors_search_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE books
+    SET search = book_search_vector(title, book_author_names(id), description)
+    WHERE id IN (SELECT book_id FROM book_authors WHERE author_id = NEW.id);
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER authors_search_update
+    AFTER UPDATE OF name ON authors
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION authors_search_update();
+
+UPDATE books SET search = book_search_vector(title, book_author_names(id), description);
+
+CREATE INDEX idx_books_search ON books USING gin (search);
@@ -0,0 +1 @@
+DROP TABLE import_reports;
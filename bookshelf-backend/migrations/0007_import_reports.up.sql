@@ -0,0 +1,14 @@
+CREATE TABLE import_reports (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    filename   text NOT NULL DEFAULT '',
+    format     text NOT NULL,
+    dry_run    boolean NOT NULL DEFAULT false,
+    rows       integer NOT NULL DEFAULT 0,
+    created    integer NOT NULL DEFAULT 0,
+    updated    integer NOT NULL DEFAULT 0,
+    failed     integer NOT NULL DEFAULT 0,
+    errors     jsonb NOT NULL DEFAULT '[]',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_import_reports_user_id ON import_reports (user_id);
@@ -0,0 +1,4 @@
+DELETE FROM books WHERE deleted_at IS NOT NULL;
+DROP INDEX idx_books_isbn13;
+CREATE UNIQUE INDEX idx_books_isbn13 ON books (isbn13);
+ALTER TABLE books DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+-- Deleted books stay in the trash until purged. Their ISBN is free for a
+-- new book meanwhile; restoring answers 409 if it has been taken.
+ALTER TABLE books ADD COLUMN deleted_at timestamptz;
+CREATE INDEX idx_books_deleted_at ON books (deleted_at);
+
+DROP INDEX idx_books_isbn13;
+CREATE UNIQUE INDEX idx_books_isbn13 ON books (isbn13) WHERE deleted_at IS NULL;
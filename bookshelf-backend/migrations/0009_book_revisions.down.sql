@@ -0,0 +1 @@
+DROP TABLE book_revisions;
@@ -0,0 +1,14 @@
+-- Every create, update, delete, restore and revert of a book, numbered per
+-- book. user_id is not a foreign key so history outlives deleted accounts.
+CREATE TABLE book_revisions (
+    id         bigserial PRIMARY KEY,
+    book_id    bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    revision   integer NOT NULL,
+    action     text NOT NULL,
+    user_id    bigint,
+    before     jsonb,
+    after      jsonb,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX idx_book_revisions_book_revision ON book_revisions (book_id, revision);
+CREATE INDEX idx_book_revisions_user_id ON book_revisions (user_id);
@@ -0,0 +1,3 @@
+-- Bumped on every change to a book, including renames of its authors,
+-- categories and publishers; the ETag of GET /books/:id.
+ALTER TABLE books ADD COLUMN version integer NOT NULL DEFAULT 1;
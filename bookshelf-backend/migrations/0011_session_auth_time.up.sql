@@ -0,0 +1,4 @@
+-- When the user logged in to start the session, carried over on rotation
+-- into the auth_time claim of its access tokens. NULL for sessions started
+-- before this column, which never count as a recent login.
+ALTER TABLE refresh_tokens ADD COLUMN auth_time timestamptz;
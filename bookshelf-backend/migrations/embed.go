@@ -0,0 +1,13 @@
+// Package migrations holds the versioned SQL schema migrations, embedded
+// into the binary and applied by internal/migrate.
+//
+// Each version N has a pair of files, NNNN_name.up.sql and
+// NNNN_name.down.sql. Applied files must never be edited; their checksum
+// is recorded in schema_migrations and verified on every run. Add a new
+// version instead.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,17 +1,42 @@
 package models
 
-import "time"
+import (
+	"time"
 
-// Book represents a book entity.
+	"gorm.io/gorm"
+)
+
+// Book represents a book entity. The binding tags are checked after a
+// request has been applied to it.
 // swagger:model Book
 type Book struct {
-	ID        uint      `json:"id"        gorm:"primaryKey"`
-	Title     string    `json:"title"     gorm:"index;not null" example:"1984"`
-	Author    string    `json:"author"    example:"George Orwell"`
-	Category  string    `json:"category"  example:"Fiction"`
-	Price     float64   `json:"price"     example:"60000"`
-	Stock     int       `json:"stock"     example:"9"`
-	CoverURL  string    `json:"coverUrl"  example:"/uploads/uuid.jpg"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID            uint        `json:"id"         gorm:"primaryKey"`
+	Title         string      `json:"title"      gorm:"index;not null" binding:"required,max=500" example:"1984"`
+	ISBN13        *string     `json:"isbn13"     gorm:"column:isbn13;uniqueIndex" example:"9780451524935"`
+	ISBN10        *string     `json:"isbn10"     gorm:"column:isbn10;index" example:"0451524934"`
+	Authors       []Author    `json:"authors"    gorm:"many2many:book_authors"`
+	Categories    []Category  `json:"categories" gorm:"many2many:book_categories"`
+	Publishers    []Publisher `json:"publishers" gorm:"many2many:book_publishers"`
+	Description   string      `json:"description"   example:"A dystopian novel about total surveillance."`
+	PageCount     *int        `json:"pageCount"     binding:"omitempty,gte=0" example:"328"`
+	PublishedDate string      `json:"publishedDate" example:"1949"`
+	Price         float64     `json:"price"      binding:"gte=0" example:"60000"`
+	Stock         int         `json:"stock"      binding:"gte=0" example:"9"`
+	CoverURL      string      `json:"coverUrl"   example:"/uploads/uuid.jpg"`
+	Version       int         `json:"version"    gorm:"not null;default:1" example:"3"` // bumped on every change; the ETag
+	CreatedAt     time.Time   `json:"createdAt"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+	// DeletedAt is set while the book is in the trash.
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index" swaggertype:"string" format:"date-time"`
+
+	// Highlight is only set on search results.
+	Highlight *BookHighlight `json:"highlight,omitempty" gorm:"-"`
+}
+
+// BookHighlight holds search snippets with the matched words wrapped in
+// <b></b>. Fields without a match are the start of the text.
+type BookHighlight struct {
+	Title       string `json:"title"       example:"<b>1984</b>"`
+	Authors     string `json:"authors"     example:"George <b>Orwell</b>"`
+	Description string `json:"description" example:"A dystopian novel about total <b>surveillance</b>."`
 }
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Revision actions.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// BookRevision records one change to a book: the book as it was before
+// and after, and who made it. Before is nil for create and restore, After
+// for delete.
+type BookRevision struct {
+	ID        uint          `json:"id"        gorm:"primaryKey"`
+	BookID    uint          `json:"bookId"    gorm:"index;not null"`
+	Revision  int           `json:"revision"  example:"3"`
+	Action    string        `json:"action"    example:"update"`
+	UserID    *uint         `json:"userId"    example:"1"`
+	UserName  string        `json:"userName"  gorm:"->;-:migration" example:"Admin"`
+	Before    *BookSnapshot `json:"before"    gorm:"serializer:json;type:jsonb"`
+	After     *BookSnapshot `json:"after"     gorm:"serializer:json;type:jsonb"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// BookSnapshot is the state of a book kept in its revisions.
+type BookSnapshot struct {
+	Title         string         `json:"title"`
+	ISBN13        *string        `json:"isbn13"`
+	ISBN10        *string        `json:"isbn10"`
+	Authors       []SnapshotTerm `json:"authors"`
+	Categories    []SnapshotTerm `json:"categories"`
+	Publishers    []SnapshotTerm `json:"publishers"`
+	Description   string         `json:"description"`
+	PageCount     *int           `json:"pageCount"`
+	PublishedDate string         `json:"publishedDate"`
+	Price         float64        `json:"price"`
+	Stock         int            `json:"stock"`
+	CoverURL      string         `json:"coverUrl"`
+}
+
+// SnapshotTerm is a linked author, category or publisher as it was named
+// at the time.
+type SnapshotTerm struct {
+	ID   uint   `json:"id"   example:"3"`
+	Name string `json:"name" example:"George Orwell"`
+}
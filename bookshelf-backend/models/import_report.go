@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ImportReport records the outcome of a POST /books/import run, so the
+// rows that failed can be downloaded, fixed and sent again.
+type ImportReport struct {
+	ID        uint             `json:"id"        gorm:"primaryKey"`
+	UserID    uint             `json:"userId"    gorm:"index;not null"`
+	Filename  string           `json:"filename"  example:"catalog.csv"`
+	Format    string           `json:"format"    example:"csv"`
+	DryRun    bool             `json:"dryRun"`
+	Rows      int              `json:"rows"      example:"1200"`
+	Created   int              `json:"created"   example:"1150"`
+	Updated   int              `json:"updated"   example:"40"`
+	Failed    int              `json:"failed"    example:"10"`
+	Errors    []ImportRowError `json:"errors"    gorm:"serializer:json;type:jsonb"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// ImportRowError is one rejected row: its number in the file (the CSV
+// header is row 1), what was wrong and the row as read.
+type ImportRowError struct {
+	Row    int               `json:"row"    example:"17"`
+	Field  string            `json:"field"  example:"isbn"`
+	Error  string            `json:"error"  example:"invalid ISBN"`
+	Record map[string]string `json:"record"`
+}
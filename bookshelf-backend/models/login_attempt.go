@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed logins for a throttling key such as an
+// account email or a client IP.
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+	UpdatedAt     time.Time
+}
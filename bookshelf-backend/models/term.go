@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Term is the shape shared by the lookup entities books link to. Names are
+// unique regardless of case.
+type Term struct {
+	ID        uint      `json:"id"        gorm:"primaryKey"`
+	Name      string    `json:"name"      gorm:"not null" example:"George Orwell"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Author of a book.
+// swagger:model Author
+type Author struct{ Term }
+
+// Category a book is filed under.
+// swagger:model Category
+type Category struct{ Term }
+
+// Publisher of a book.
+// swagger:model Publisher
+type Publisher struct{ Term }
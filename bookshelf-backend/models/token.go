@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived, single-use token exchanged for a new access
+// token at /auth/refresh. Only the SHA-256 hash of the token is stored.
+// All tokens rotated from the same login share a FamilyID, which is also the
+// "sid" claim of the access tokens issued alongside them.
+type RefreshToken struct {
+	ID           uint       `json:"id"        gorm:"primaryKey"`
+	UserID       uint       `json:"userId"    gorm:"index;not null"`
+	FamilyID     string     `json:"-"         gorm:"index;not null"`
+	TokenHash    string     `json:"-"         gorm:"uniqueIndex;not null"`
+	ExpiresAt    time.Time  `json:"expiresAt" gorm:"not null"`
+	RevokedAt    *time.Time `json:"revokedAt"`
+	ReplacedByID *uint      `json:"-"`
+	AMR          string     `json:"-"` // comma separated auth methods of the login, carried over on rotation
+	AuthTime     *time.Time `json:"-"` // when the login happened, carried over on rotation
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// RevokedToken is a deny-list entry for an access token, keyed by its jti.
+// Rows can be dropped once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// Purposes of an ActionToken.
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeVerifyEmail   = "verify_email"
+	PurposeChangeEmail   = "change_email"
+)
+
+// ActionToken is a single-use, expiring token mailed to a user to prove
+// control of their address, e.g. for a password reset. Only its SHA-256
+// hash is stored.
+type ActionToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	Purpose   string    `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a one-time fallback for a lost authenticator. Only the
+// SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"index;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// APIKey is a long-lived credential for scripts and integrations, sent as
+// "Authorization: ApiKey <key>". The key is shown once at creation; only
+// its Prefix (for lookup and display) and SHA-256 hash are stored.
+type APIKey struct {
+	ID         uint       `json:"id"         gorm:"primaryKey"`
+	UserID     uint       `json:"userId"     gorm:"index;not null"`
+	Name       string     `json:"name"       gorm:"not null" example:"nightly import"`
+	Prefix     string     `json:"prefix"     gorm:"uniqueIndex;not null" example:"bks_k3md9qxa"`
+	KeyHash    string     `json:"-"          gorm:"not null"`
+	Scopes     string     `json:"-"          gorm:"not null"` // space separated permissions
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
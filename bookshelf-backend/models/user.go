@@ -2,14 +2,47 @@ package models
 
 import "time"
 
+// Roles understood by the authorization policy in internal/auth.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
 // User represents an account.
 // swagger:model User
 type User struct {
-	ID        uint      `json:"id"        gorm:"primaryKey"`
-	Email     string    `json:"email"     gorm:"uniqueIndex"`
-	Password  string    `json:"-"` // never expose
-	Name      string    `json:"name"`
-	Role      string    `json:"role" example:"admin"`
+	ID       uint   `json:"id"        gorm:"primaryKey"`
+	Email    string `json:"email"     gorm:"uniqueIndex"`
+	Password string `json:"-"` // never expose
+	Name     string `json:"name"`
+	Role     string `json:"role"      gorm:"default:viewer" example:"admin"`
+
+	// EmailVerifiedAt is set once the link mailed on signup is followed.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// PendingEmail replaces Email once the link mailed to it is followed.
+	PendingEmail *string `json:"pendingEmail"`
+
+	// Login bookkeeping; FailedLoginCount resets on every successful login.
+	LastLoginAt      *time.Time `json:"lastLoginAt"`
+	FailedLoginCount int        `json:"failedLoginCount" gorm:"not null;default:0"`
+
+	// DisabledAt is set by an admin to block the account; while set the
+	// user can't log in and existing credentials stop working.
+	DisabledAt *time.Time `json:"disabledAt" gorm:"index"`
+	// AnonymizedAt is set when the user deleted their own account; the row
+	// stays so that ids referenced elsewhere keep resolving.
+	AnonymizedAt *time.Time `json:"anonymizedAt"`
+
+	// TOTP two-factor state. The secret (base32) is pending until
+	// TOTPEnabledAt is set; TOTPLastStep blocks replay of accepted codes.
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt"`
+	TOTPLastStep  int64      `json:"-"`
+
+	// OIDCSubject is "<issuer>#<sub>" of a linked identity provider account.
+	OIDCSubject *string `json:"-" gorm:"uniqueIndex"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -12,11 +12,43 @@ api.interceptors.request.use((config) => {
 });
 
 
+// Access tokens are short-lived; on the first 401 try to swap the refresh
+// token for a new pair and replay the request once.
+let refreshing = null;
+
+function refreshTokens() {
+  const refreshToken = localStorage.getItem("refreshToken");
+  if (!refreshToken) return Promise.reject(new Error("no refresh token"));
+  return axios
+    .post(`${import.meta.env.VITE_API_BASE}/auth/refresh`, { refreshToken })
+    .then(({ data }) => {
+      localStorage.setItem("token", data.token);
+      localStorage.setItem("refreshToken", data.refreshToken);
+      localStorage.setItem("user", JSON.stringify(data.user || null));
+      return data.token;
+    });
+}
+
 api.interceptors.response.use(
   (res) => res,
-  (err) => {
+  async (err) => {
+    const cfg = err.config;
+    if (err.response && err.response.status === 401 && cfg && !cfg._retried && !cfg.url?.startsWith("/auth/")) {
+      cfg._retried = true;
+      try {
+        refreshing = refreshing || refreshTokens();
+        const token = await refreshing;
+        cfg.headers.Authorization = `Bearer ${token}`;
+        return api(cfg);
+      } catch {
+        // fall through to clearing the session
+      } finally {
+        refreshing = null;
+      }
+    }
     if (err.response && err.response.status === 401) {
       localStorage.removeItem("token");
+      localStorage.removeItem("refreshToken");
       localStorage.removeItem("user");
      
     }
@@ -24,4 +56,18 @@ api.interceptors.response.use(
   }
 );
 
+// Updates and deletes must name the version of the book they were made
+// from; the server answers 412 if someone else changed it meanwhile.
+export const ifMatch = (book) => ({ "If-Match": `"${book.version}"` });
+
+// errorText turns a failed request into a message. Errors come as
+// application/problem+json; the request id is kept for bug reports and
+// invalid fields of a 400 get a line each.
+export function errorText(e) {
+  const p = e?.response?.data;
+  const fields = Object.entries(p?.fields ?? {}).map(([k, v]) => `${k}: ${v}`);
+  const ref = p?.status >= 500 && p.requestId ? ` (request ${p.requestId})` : "";
+  return [(p?.detail || p?.title || e.message) + ref, ...fields].join("\n");
+}
+
 export default api;
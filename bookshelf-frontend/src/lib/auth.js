@@ -2,20 +2,27 @@ import { reactive, computed } from "vue";
 
 const state = reactive({
   token: localStorage.getItem("token") || "",
+  refreshToken: localStorage.getItem("refreshToken") || "",
   user: JSON.parse(localStorage.getItem("user") || "null"),
 });
 
-function setAuth(token, user) {
+function setAuth(token, user, refreshToken) {
   state.token = token;
   state.user = user || null;
   localStorage.setItem("token", token);
   localStorage.setItem("user", JSON.stringify(user || null));
+  if (refreshToken !== undefined) {
+    state.refreshToken = refreshToken || "";
+    localStorage.setItem("refreshToken", refreshToken || "");
+  }
 }
 
 function logout() {
   state.token = "";
+  state.refreshToken = "";
   state.user = null;
   localStorage.removeItem("token");
+  localStorage.removeItem("refreshToken");
   localStorage.removeItem("user");
 }
 
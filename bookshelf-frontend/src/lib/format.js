@@ -1,3 +1,9 @@
+// names joins the names of linked authors/categories/publishers.
+export function names(list, fallback = "") {
+  const out = (list ?? []).map((x) => x.name).join(", ");
+  return out || fallback;
+}
+
 export function formatIDR(value, { fractionDigits = 0 } = {}) {
   const n = Number(value);
   if (!Number.isFinite(n)) return "Rp 0";
@@ -8,3 +14,14 @@ export function formatIDR(value, { fractionDigits = 0 } = {}) {
     maximumFractionDigits: fractionDigits,
   }).format(n);
 }
+
+// highlight turns a search snippet into safe HTML: everything is escaped
+// except the <b></b> the API puts around matches, which become <mark>.
+export function highlight(snippet) {
+  const esc = String(snippet ?? "")
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+  return esc.replace(/&lt;b&gt;/g, "<mark>").replace(/&lt;\/b&gt;/g, "</mark>");
+}
@@ -2,11 +2,13 @@ import { createRouter, createWebHistory } from "vue-router";
 import BooksPage from "./views/BooksPage.vue";
 import BookDetail from "./views/BookDetail.vue";
 import LoginPage from "./views/LoginPage.vue";
+import OidcCallback from "./views/OidcCallback.vue";
 import { useAuth } from "./lib/auth";
 
 const routes = [
   { path: "/", redirect: "/books" },
   { path: "/login", component: LoginPage, meta: { guest: true } },
+  { path: "/login/oidc", component: OidcCallback },
   { path: "/books", component: BooksPage },
   { path: "/books/:id", component: BookDetail, props: true },
 ];